@@ -0,0 +1,86 @@
+package zerver
+
+import (
+	"net"
+	"sync"
+)
+
+type (
+	// acceptGate pause accepting connections of all listeners once connections
+	// in service reach the high water mark, until they drop to the low one, new
+	// clients wait in the listen backlog of kernel. It's counted by
+	// Server.connStateHook on active transitions, so idle keep-alive
+	// connections don't hold the gate
+	acceptGate struct {
+		high, low int
+
+		mu     sync.Mutex
+		cond   *sync.Cond
+		active int
+		paused bool
+		closed bool
+	}
+
+	gateListener struct {
+		net.Listener
+		gate *acceptGate
+		once sync.Once
+	}
+)
+
+func newAcceptGate(high, low int) *acceptGate {
+	g := &acceptGate{high: high, low: low}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *acceptGate) wrap(l net.Listener) net.Listener {
+	return &gateListener{Listener: l, gate: g}
+}
+
+// wait block until accepting is allowed or gate is closed
+func (g *acceptGate) wait() {
+	g.mu.Lock()
+	for g.paused && !g.closed {
+		g.cond.Wait()
+	}
+	g.mu.Unlock()
+}
+
+// acquire is called when a connection become active
+func (g *acceptGate) acquire() {
+	g.mu.Lock()
+	g.active++
+	if g.active >= g.high {
+		g.paused = true
+	}
+	g.mu.Unlock()
+}
+
+// release is called when a connection leave the active state
+func (g *acceptGate) release() {
+	g.mu.Lock()
+	g.active--
+	if g.paused && g.active <= g.low {
+		g.paused = false
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+func (g *acceptGate) close() {
+	g.mu.Lock()
+	g.closed = true
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+func (l *gateListener) Accept() (net.Conn, error) {
+	l.gate.wait() // if gate is closed, listener is also closed and return the error
+	return l.Listener.Accept()
+}
+
+func (l *gateListener) Close() error {
+	l.once.Do(l.gate.close)
+	return l.Listener.Close()
+}
@@ -0,0 +1,46 @@
+package zerver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAcceptGate(t *testing.T) {
+	s := newTestServer(t, nil, nil)
+	s.gate = newAcceptGate(2, 1)
+	paused := func() bool {
+		s.gate.mu.Lock()
+		defer s.gate.mu.Unlock()
+		return s.gate.paused
+	}
+	transit := func(c *fakeConn, states ...http.ConnState) {
+		for _, state := range states {
+			s.connStateHook(c, state)
+		}
+	}
+
+	// idle keep-alive connections don't hold the gate
+	for i := 0; i < 10; i++ {
+		transit(&fakeConn{id: i}, http.StateNew, http.StateActive, http.StateIdle)
+	}
+	if paused() {
+		t.Fatal("gate paused by idle connections")
+	}
+
+	a, b := &fakeConn{id: 10}, &fakeConn{id: 11}
+	transit(a, http.StateNew, http.StateActive)
+	transit(b, http.StateNew, http.StateActive)
+	if !paused() {
+		t.Fatal("gate not paused at high water")
+	}
+	transit(a, http.StateIdle)
+	if paused() {
+		t.Fatal("gate still paused at low water")
+	}
+	transit(a, http.StateActive)
+	transit(b, http.StateHijacked)
+	transit(a, http.StateClosed)
+	if s.gate.active != 0 {
+		t.Fatalf("active: want 0, got %d", s.gate.active)
+	}
+}
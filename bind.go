@@ -0,0 +1,156 @@
+package zerver
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/cosiner/gohper/errors"
+)
+
+const (
+	ErrBindTarget = errors.Err("bind target must be a pointer to struct")
+)
+
+type (
+	// Validator will be called after binding if bind target implement it
+	Validator interface {
+		Validate() error
+	}
+
+	// BindError describe the parameter failed to convert
+	BindError struct {
+		Name  string
+		Value string
+		Err   error
+	}
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (e *BindError) Error() string {
+	return "bind parameter " + e.Name + "=" + strconv.Quote(e.Value) + ": " + e.Err.Error()
+}
+
+// bindValues bind values returned by lookup into struct fields by tag, fields
+// with tag "-" are skipped, and `default:"..."` is used if value is absent.
+// Nested or embedded structs are bound recursively. Supported field types are
+// string, bool, ints, uints, floats, time.Duration and slices of them, slice
+// fields collect all values of repeated parameters.
+func bindValues(lookup func(string) []string, tag string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+
+	err := bindStruct(lookup, tag, rv.Elem())
+	if err == nil {
+		if validator, is := v.(Validator); is {
+			err = validator.Validate()
+		}
+	}
+	return err
+}
+
+func bindStruct(lookup func(string) []string, tag string, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		fv := rv.Field(i)
+		if field.PkgPath != "" && !field.Anonymous { // unexported
+			continue
+		}
+
+		name := field.Tag.Get(tag)
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			if field.Type.Kind() == reflect.Struct {
+				if err := bindStruct(lookup, tag, fv); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !fv.CanSet() {
+			continue
+		}
+		values := lookup(name)
+		if len(values) == 0 {
+			def, has := field.Tag.Lookup("default")
+			if !has {
+				continue
+			}
+			values = []string{def}
+		}
+
+		if err := bindField(fv, values); err != nil {
+			return &BindError{Name: name, Value: values[0], Err: err}
+		}
+	}
+	return nil
+}
+
+func bindField(fv reflect.Value, values []string) error {
+	if fv.Kind() != reflect.Slice {
+		return setValue(fv, values[0])
+	}
+
+	slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+	for i, val := range values {
+		if err := setValue(slice.Index(i), val); err != nil {
+			return err
+		}
+	}
+	fv.Set(slice)
+	return nil
+}
+
+func setValue(fv reflect.Value, val string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err == nil {
+			fv.SetInt(int64(d))
+		}
+		return err
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Ptr:
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setValue(fv.Elem(), val)
+	default:
+		return errors.Err("unsupported field type " + fv.Type().String())
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package zerver
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/cosiner/gohper/encoding"
 	"github.com/cosiner/gohper/errors"
@@ -17,6 +20,8 @@ const (
 	_UNINITIALIZE compState = iota
 	_WAITING
 	_INITIALIZED
+	_DISABLED
+	_FAILED
 )
 
 func (s compState) String() string {
@@ -27,6 +32,10 @@ func (s compState) String() string {
 		return "Initializing"
 	case _INITIALIZED:
 		return "Initialized"
+	case _DISABLED:
+		return "Disabled"
+	case _FAILED:
+		return "Failed"
 	}
 
 	panic("unexpected initial state")
@@ -40,7 +49,7 @@ type (
 	Env interface {
 		Server() *Server
 		Filepath(path string) string
-		StartTask(path string, value interface{})
+		StartTask(path string, value interface{}) error
 		Component(name string) (interface{}, error)
 		Codec() encoding.Codec
 		Logger() *log.Logger
@@ -53,13 +62,82 @@ type (
 		Destroy()
 	}
 
+	// Configurable component will be configured by the config setted by
+	// Server.SetComponentConfig before Init
+	Configurable interface {
+		Configure(cfg interface{}) error
+	}
+
+	// DestroyTimeouter specify the max time to wait component Destroy, it
+	// override ServerOption.ComponentDestroyTimeout, 0 means wait forever
+	DestroyTimeouter interface {
+		DestroyTimeout() time.Duration
+	}
+
+	// Enabler decide whether component should be used in current environment,
+	// it's checked before Configure and Init, disabled components are never
+	// initialized or destroyed, accessing a disabled named component get
+	// ErrCompDisabled
+	Enabler interface {
+		Enabled(Env) bool
+	}
+
 	NopComponent struct{}
+
+	// ComponentInfo describe a managed component
+	ComponentInfo struct {
+		// for anonymous component, it's the type name
+		Name        string
+		Anonymous   bool
+		Initialized bool
+		Disabled    bool
+	}
 )
 
 func (NopComponent) Init(Env) error { return nil }
 
 func (NopComponent) Destroy() {}
 
+func componentEnabled(env Env, c interface{}) bool {
+	e, is := c.(Enabler)
+	return !is || e.Enabled(env)
+}
+
+// initComponent init component and log the time it takes, if it's slower than
+// ServerOption.SlowInitThreshold, a warning is logged
+//
+// During server config, outermost handlers and filters initialized
+// successfully are recorded to be destroyed if server init failed, nested ones
+// are destroyed by their owner. Components are tracked by CompManager.
+func initComponent(env Env, kind, name string, c Component) error {
+	s := env.Server()
+	track := s.initTracking && kind != "component"
+	if track {
+		s.initDepth++
+	}
+	begin := time.Now()
+	err := c.Init(env)
+	cost := time.Since(begin)
+	if track {
+		if s.initDepth--; s.initDepth == 0 && err == nil {
+			s.initialized = append(s.initialized, c)
+		}
+	}
+
+	if s.log == nil {
+		return err
+	}
+	if name == "" {
+		name = fmt.Sprintf("%T", c)
+	}
+	if s.slowInit > 0 && cost >= s.slowInit {
+		s.log.Warn(log.M{"msg": "slow " + kind + " init", "name": name, "cost": cost.String()})
+	} else if s.log.IsDebugEnable() {
+		s.log.Debug(log.M{"msg": kind + " init", "name": name, "cost": cost.String()})
+	}
+	return err
+}
+
 // =============================================================================
 //                                  Component Environment
 // =============================================================================
@@ -71,6 +149,8 @@ type CompEnv struct {
 	Env
 
 	state compState
+	err   error    // error of Configure or Init if state is failed
+	deps  []string // components accessed during initialization
 }
 
 func newCompEnv(env Env, name string, c interface{}) *CompEnv {
@@ -95,6 +175,22 @@ func (e *CompEnv) Name() string {
 	return e.name
 }
 
+// Component get component by name, components accessed during initialization
+// are recorded as dependencies, they will be destroyed after this one.
+func (e *CompEnv) Component(name string) (interface{}, error) {
+	if e.state == _WAITING {
+		e.deps = append(e.deps, name)
+	}
+	return e.Env.Component(name)
+}
+
+// Config return the config of component setted by Server.SetComponentConfig,
+// nil if not setted
+func (e *CompEnv) Config() interface{} {
+	cfg, _ := e.Server().components.Config(e.name)
+	return cfg
+}
+
 func (e *CompEnv) String() string {
 	return e.name + ":" + e.state.String()
 }
@@ -115,20 +211,59 @@ func (e *CompEnv) GetSetAttr(name string, val interface{}) interface{} {
 	return e.Server().GetSetAttr(ComponentAttr(e.name, name), val)
 }
 
+// Intercept insert filter into chains of routes matched pattern, it's same as
+// Router.Filter, so components such as cache can wire themselves into request
+// path in Init instead of requiring application add their filters, the Env
+// passed to component Init is the *CompEnv.
+//
+// Filters on same pattern run in registration order, routes registered by
+// application before server start usually come first. The filter is
+// initialized by router after all components, and destroyed with router before
+// components. It must be called during Init of component registered before
+// server start, otherwise ErrInterceptOutOfInit is returned.
+func (e *CompEnv) Intercept(pattern string, f Filter) error {
+	s := e.Server()
+	if e.state != _WAITING || s.routesInited {
+		return ErrInterceptOutOfInit
+	}
+	return s.Router.Filter(pattern, f)
+}
+
 func (e *CompEnv) Init(Env) error {
-	if e.state == _INITIALIZED {
+	if e.state == _INITIALIZED || e.state == _DISABLED {
 		return nil
 	}
+	if e.state == _FAILED {
+		return e.err
+	}
 
 	if e.state == _WAITING {
 		panic("Cycle dependence on " + e.name)
 	}
 
+	if !componentEnabled(e, e.comp) {
+		e.state = _DISABLED
+		return nil
+	}
+
 	e.state = _WAITING
-	err := e.comp.Init(e)
+	var err error
+	if c, is := e.comp.(Configurable); is {
+		if cfg, has := e.Server().components.Config(e.name); has {
+			err = c.Configure(cfg)
+		}
+	}
+	if err == nil {
+		err = initComponent(e, "component", e.name, e.comp)
+	}
+	if err != nil {
+		e.state, e.err = _FAILED, err
+		return err
+	}
 	e.state = _INITIALIZED
+	e.Server().components.initialized(e.name)
 
-	return err
+	return nil
 }
 
 func (e *CompEnv) Destroy() {
@@ -148,20 +283,56 @@ func (e *CompEnv) underlay() interface{} {
 // =============================================================================
 //                                  Component Manager
 // =============================================================================
-var ErrCompNotFound = errors.New("component not found")
+var (
+	ErrCompNotFound = errors.New("component not found")
+	ErrCompDisabled = errors.New("component disabled")
 
+	ErrInterceptOutOfInit = errors.New("intercept must be called during component init before server start")
+)
+
+// CompManager manage components lifetime.
+//
+// Components registered before manager initialized(server start) will be
+// initialized in Init, named first, then anonymous, named component may be
+// initialized earlier if it's accessed by Get. Components registered after
+// that will be initialized immediately during registration, if failed, error
+// is returned and the component will not be registered.
 type CompManager struct {
 	components map[string]*CompEnv
+	order      []string // named components in initialization order
+	configs    map[string]interface{}
 	anonymous  []Component
+	inited     bool
 	mu         sync.RWMutex
+
+	destroyTimeout  time.Duration
+	parallelDestroy bool
+	log             *log.Logger
 }
 
 func NewCompManager() CompManager {
 	return CompManager{
 		components: make(map[string]*CompEnv),
+		configs:    make(map[string]interface{}),
 	}
 }
 
+// SetConfig set config of named component, it must be called before the
+// component initialized
+func (m *CompManager) SetConfig(name string, cfg interface{}) {
+	m.mu.Lock()
+	m.configs[name] = cfg
+	m.mu.Unlock()
+}
+
+// Config return config of named component
+func (m *CompManager) Config(name string) (interface{}, bool) {
+	m.mu.RLock()
+	cfg, has := m.configs[name]
+	m.mu.RUnlock()
+	return cfg, has
+}
+
 func (m *CompManager) Get(name string) (interface{}, error) {
 	m.mu.RLock()
 	e, has := m.components[name]
@@ -174,27 +345,78 @@ func (m *CompManager) Get(name string) (interface{}, error) {
 	if err := e.Init(e); err != nil { // only first time will execute
 		return nil, err
 	}
+	if e.state == _DISABLED {
+		return nil, ErrCompDisabled
+	}
 
 	return e.underlay(), nil
 }
 
+// Infos return informations of all components, named first and sorted by name,
+// then anonymous in registration order
+func (m *CompManager) Infos() []ComponentInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	infos := make([]ComponentInfo, 0, len(m.components)+len(m.anonymous))
+	for name, cs := range m.components {
+		infos = append(infos, ComponentInfo{
+			Name:        name,
+			Initialized: cs.state == _INITIALIZED,
+			Disabled:    cs.state == _DISABLED,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	for _, c := range m.anonymous {
+		infos = append(infos, ComponentInfo{
+			Name:        fmt.Sprintf("%T", c),
+			Anonymous:   true,
+			Initialized: m.inited, // anonymous components are all initialized at start
+		})
+	}
+	return infos
+}
+
 // Register make a component managed
-func (m *CompManager) Register(env Env, name string, comp interface{}) *CompEnv {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *CompManager) Register(env Env, name string, comp interface{}) (*CompEnv, error) {
+	m.mu.RLock()
+	inited := m.inited
+	m.mu.RUnlock()
 
 	if name == "" {
-		if c, is := comp.(Component); is {
-			m.anonymous = append(m.anonymous, c)
-		} else {
+		c, is := comp.(Component)
+		if !is {
 			panic("non-component object shouldn't be add to manager anonymously")
 		}
-		return nil
+		if inited {
+			if !componentEnabled(env, c) {
+				return nil, nil
+			}
+			if err := c.Init(env); err != nil {
+				return nil, err
+			}
+		}
+
+		m.mu.Lock()
+		m.anonymous = append(m.anonymous, c)
+		m.mu.Unlock()
+		return nil, nil
 	}
 
 	cs := newCompEnv(env, name, comp)
+	if inited {
+		if err := cs.Init(cs); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
 	m.components[name] = cs
-	return cs
+	m.mu.Unlock()
+	return cs, nil
 }
 
 // Remove will an component and Destroy it
@@ -209,7 +431,18 @@ func (m *CompManager) Remove(name string) {
 	}
 }
 
+// initialized record the named component has been initialized
+func (m *CompManager) initialized(name string) {
+	m.mu.Lock()
+	m.order = append(m.order, name)
+	m.mu.Unlock()
+}
+
 func (m *CompManager) Init(e Env) error {
+	m.mu.Lock()
+	m.inited = true
+	m.mu.Unlock()
+
 	// initial named component first for anonymous may depend on them
 	for _, comp := range m.components {
 		if err := comp.Init(e); err != nil {
@@ -217,23 +450,133 @@ func (m *CompManager) Init(e Env) error {
 		}
 	}
 
+	anonymous := make([]Component, 0, len(m.anonymous))
 	for _, c := range m.anonymous {
+		if !componentEnabled(e, c) {
+			continue
+		}
 		if err := c.Init(e); err != nil {
+			m.mu.Lock()
+			m.anonymous = anonymous // only destroy initialized ones
+			m.mu.Unlock()
 			return err
 		}
+		anonymous = append(anonymous, c)
 	}
+	m.mu.Lock()
+	m.anonymous = anonymous
+	m.mu.Unlock()
 
 	return nil
 }
 
+// Destroy all components in reverse order of initialization: anonymous first
+// for they may depend on named components, then named.
+//
+// Each Destroy is waited with the timeout of component or the global one, if
+// exceeded, a warning is logged and the next one is destroyed.
+//
+// If parallel destroy is enabled, components are destroyed concurrently, but
+// components still outlive those depend on them.
 func (m *CompManager) Destroy() {
 	m.mu.Lock()
-	for _, cs := range m.components {
-		cs.Destroy()
+	if m.parallelDestroy {
+		m.destroyParallel()
+		m.mu.Unlock()
+		return
+	}
+
+	for i := len(m.anonymous) - 1; i >= 0; i-- {
+		c := m.anonymous[i]
+		m.destroy("", c, c)
 	}
 
+	destroyed := make(map[string]bool, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		name := m.order[i]
+		cs, has := m.components[name]
+		if !has || destroyed[name] { // removed or registered again
+			continue
+		}
+		destroyed[name] = true
+		m.destroy(name, cs.underlay(), cs)
+	}
+	m.mu.Unlock()
+}
+
+func (m *CompManager) destroyParallel() {
+	var wg sync.WaitGroup
 	for _, c := range m.anonymous {
+		wg.Add(1)
+		go func(c Component) {
+			m.destroy("", c, c)
+			wg.Done()
+		}(c)
+	}
+	wg.Wait()
+
+	// same as sequential destroy, only initialized ones
+	comps := make(map[string]*CompEnv, len(m.order))
+	for _, name := range m.order {
+		if cs, has := m.components[name]; has {
+			comps[name] = cs
+		}
+	}
+	done := make(map[string]chan struct{}, len(comps))
+	for name := range comps {
+		done[name] = make(chan struct{})
+	}
+	dependents := make(map[string][]string)
+	for name, cs := range comps {
+		for _, dep := range cs.deps {
+			if _, has := done[dep]; has && dep != name {
+				dependents[dep] = append(dependents[dep], name)
+			}
+		}
+	}
+
+	for name, cs := range comps {
+		wg.Add(1)
+		go func(name string, cs *CompEnv) {
+			for _, d := range dependents[name] {
+				<-done[d]
+			}
+			m.destroy(name, cs.underlay(), cs)
+			close(done[name])
+			wg.Done()
+		}(name, cs)
+	}
+	wg.Wait()
+}
+
+// destroy call c.Destroy with timeout, underlay is the original component to
+// get it's timeout
+func (m *CompManager) destroy(name string, underlay interface{}, c Component) {
+	timeout := m.destroyTimeout
+	if t, is := underlay.(DestroyTimeouter); is {
+		timeout = t.DestroyTimeout()
+	}
+	if timeout <= 0 {
 		c.Destroy()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Destroy()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		if name == "" {
+			name = fmt.Sprintf("%T", underlay)
+		}
+		if m.log != nil {
+			m.log.Warn(log.M{"msg": "component destroy timeout, skipped", "name": name, "timeout": timeout.String()})
+		}
 	}
-	m.mu.Unlock()
 }
@@ -0,0 +1,93 @@
+package zerver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// orderComp record init and destroy order, deps are accessed during init
+type orderComp struct {
+	name string
+	deps []string
+	log  *[]string
+}
+
+func (c *orderComp) Init(env Env) error {
+	for _, d := range c.deps {
+		if _, err := env.Component(d); err != nil {
+			return err
+		}
+	}
+	*c.log = append(*c.log, "init "+c.name)
+	return nil
+}
+
+func (c *orderComp) Destroy() {
+	*c.log = append(*c.log, "destroy "+c.name)
+}
+
+func TestComponentDestroyOrder(t *testing.T) {
+	for i := 0; i < 10; i++ { // init order of named components is random
+		var logs []string
+		s := NewServer("")
+		for _, c := range []*orderComp{
+			{name: "db"},
+			{name: "cache", deps: []string{"db"}},
+			{name: "session", deps: []string{"cache", "db"}},
+			{name: "mail"},
+		} {
+			c.log = &logs
+			if _, err := s.RegisterComponent(c.name, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.RegisterComponent("", &orderComp{name: "anonymous", deps: []string{"session"}, log: &logs})
+		if err := s.config(&ServerOption{}); err != nil {
+			t.Fatal(err)
+		}
+		s.components.Destroy()
+
+		n := len(logs) / 2
+		expect := make([]string, n)
+		for j := 0; j < n; j++ {
+			expect[j] = "destroy " + logs[n-1-j][len("init "):]
+		}
+		if got := logs[n:]; !reflect.DeepEqual(got, expect) {
+			t.Fatalf("init %v: want destroy %v, got %v", logs[:n], expect, got)
+		}
+		if logs[n-1] != "init anonymous" {
+			t.Fatalf("anonymous component is not initialized last: %v", logs[:n])
+		}
+	}
+}
+
+// failComp fail to init and record whether it's destroyed
+type failComp struct {
+	destroyed bool
+}
+
+func (c *failComp) Init(Env) error { return fmt.Errorf("init failed") }
+func (c *failComp) Destroy()       { c.destroyed = true }
+
+func TestComponentInitFailed(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		failed := &failComp{}
+		s := NewServer("")
+		s.RegisterComponent("failed", failed)
+		err := s.config(&ServerOption{IgnoreInitErrors: true, ParallelDestroy: parallel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := s.Component("failed"); err == nil || err.Error() != "init failed" {
+				t.Fatalf("parallel %t: get failed component %d: want init error, got %v", parallel, i, err)
+			}
+		}
+
+		s.components.Destroy()
+		if failed.destroyed {
+			t.Errorf("parallel %t: failed component is destroyed", parallel)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package zerver
 
 type (
+	// FilterChain is the continuation of current filter, call it to pass
+	// request to next filter, finally the handler. A filter abort the request
+	// by returning without calling it, usually after setting status code, such
+	// as 401, see Next and Abort. Filters can also do something after it
+	// returned, the response may already written at that time. Use
+	// Request.HandlerInvoked to check whether the request is short-circuited
+	// by following filters.
 	FilterChain HandleFunc
 
 	FilterFunc func(Request, Response, FilterChain)
@@ -11,6 +18,20 @@ type (
 	}
 )
 
+// Next pass request to next filter, finally the handler, it's same as calling
+// chain directly
+func (chain FilterChain) Next(req Request, resp Response) {
+	chain(req, resp)
+}
+
+// Abort short-circuit the request with status, following filters and the
+// handler are not invoked, filter should return after it. It's same as setting
+// status and returning without calling chain, aborted requests report false
+// by Request.HandlerInvoked
+func (FilterChain) Abort(resp Response, status int) {
+	resp.StatusCode(status)
+}
+
 func NopFilterFunc(req Request, resp Response, chain FilterChain) {
 	chain(req, resp)
 }
@@ -26,20 +47,31 @@ func (fn FilterFunc) Filter(req Request, resp Response, chain FilterChain) {
 type filterChain struct {
 	filters []Filter
 	handler FilterChain
+	invoke  bool // handler is a route handler, not the NopHandleFunc
 }
 
-func newFilterChain(handler FilterChain, filters ...Filter) FilterChain {
-	if handler == nil {
-		handler = NopHandleFunc
-	}
-
-	if l := len(filters); l == 0 {
-		return handler
+// serveChain run filters then handler, nil handler means there is no route
+// handler to invoke such as 404 and 405, then it's not counted by
+// Request.HandlerInvoked. Without filters, handler is called directly.
+func serveChain(req Request, resp Response, handler FilterChain, filters []Filter) {
+	if len(filters) == 0 {
+		if handler != nil {
+			req.handlerInvoked()
+			handler(req, resp)
+		}
+		return
 	}
+	newFilterChain(handler, filters...)(req, resp)
+}
 
+func newFilterChain(handler FilterChain, filters ...Filter) FilterChain {
 	c := &filterChain{
 		filters: filters,
 		handler: handler,
+		invoke:  handler != nil,
+	}
+	if handler == nil {
+		c.handler = NopHandleFunc
 	}
 
 	return c.doChain
@@ -48,6 +80,9 @@ func newFilterChain(handler FilterChain, filters ...Filter) FilterChain {
 func (c *filterChain) doChain(req Request, resp Response) {
 	filters := c.filters
 	if len(filters) == 0 {
+		if c.invoke {
+			req.handlerInvoked()
+		}
 		c.handler(req, resp)
 	} else {
 		filter := filters[0]
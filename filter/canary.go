@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"hash/fnv"
+	"net/http"
+
+	"github.com/cosiner/gohper/net2/http2"
+	"github.com/cosiner/zerver"
+)
+
+const _CANARY_ATTR = "filter.canary"
+
+// Canary route a percentage of clients to an alternate handler, a client is
+// selected by hash of it's key, so it always get the same result.
+type Canary struct {
+	// percent of clients go to canary, 0-100
+	Percent int
+	// if nil, selected requests is only marked, see IsCanary
+	Handler zerver.Handler
+	// key to identify a client, default use client ip
+	Key func(zerver.Request) string
+}
+
+// CanaryFilter send about percent% of clients to canary handler instead of the
+// matched one, selected by hash of client ip
+func CanaryFilter(percent int, canary zerver.Handler) zerver.Filter {
+	return &Canary{
+		Percent: percent,
+		Handler: canary,
+	}
+}
+
+// IsCanary report whether request is selected by Canary filter
+func IsCanary(req zerver.Request) bool {
+	is, _ := req.Attr(_CANARY_ATTR).(bool)
+	return is
+}
+
+func (c *Canary) Init(env zerver.Env) error {
+	if c.Key == nil {
+		c.Key = func(req zerver.Request) string {
+			return http2.IpOfAddr(req.RemoteAddr())
+		}
+	}
+
+	if c.Handler != nil {
+		return c.Handler.Init(env)
+	}
+	return nil
+}
+
+func (c *Canary) Destroy() {
+	if c.Handler != nil {
+		c.Handler.Destroy()
+	}
+}
+
+func (c *Canary) selected(req zerver.Request) bool {
+	if c.Percent <= 0 {
+		return false
+	}
+	if c.Percent >= 100 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(c.Key(req)))
+	return int(h.Sum32()%100) < c.Percent
+}
+
+func (c *Canary) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	if !c.selected(req) {
+		chain(req, resp)
+		return
+	}
+
+	req.SetAttr(_CANARY_ATTR, true)
+	if c.Handler == nil {
+		chain(req, resp)
+		return
+	}
+
+	if fn := c.Handler.Handler(req.ReqMethod()); fn != nil {
+		fn(req, resp)
+	} else {
+		resp.StatusCode(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,69 @@
+package filter
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/cosiner/zerver"
+)
+
+const _CLIENT_CERT_ATTR = "filter.clientcert"
+
+type (
+	// ClientCert extract subject of verified client certificate into request
+	// attributes, see ClientCertOf. Client certs must be requested and verified
+	// by tls config of listener, such as ServerOption.CAs.
+	ClientCert struct {
+		// reject requests without verified client certificate with 403
+		Required bool
+	}
+
+	// ClientCertInfo is the subject of client certificate
+	ClientCertInfo struct {
+		CommonName     string
+		DNSNames       []string
+		EmailAddresses []string
+		IPAddresses    []string
+		URIs           []string
+		Certificate    *x509.Certificate
+	}
+)
+
+// ClientCertOf return subject of the verified client certificate, nil if there
+// is no one or ClientCert filter is absent
+func ClientCertOf(req zerver.Request) *ClientCertInfo {
+	info, _ := req.Attr(_CLIENT_CERT_ATTR).(*ClientCertInfo)
+	return info
+}
+
+func (c *ClientCert) Init(zerver.Env) error { return nil }
+
+func (c *ClientCert) Destroy() {}
+
+func (c *ClientCert) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	state := req.TLS()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		if c.Required {
+			resp.StatusCode(http.StatusForbidden)
+			return
+		}
+		chain(req, resp)
+		return
+	}
+
+	cert := state.VerifiedChains[0][0]
+	info := &ClientCertInfo{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	req.SetAttr(_CLIENT_CERT_ATTR, info)
+	chain(req, resp)
+}
@@ -12,59 +12,89 @@ import (
 	"github.com/cosiner/zerver"
 )
 
-type gzipWriter struct {
-	gw *gzip.Writer
+// compressWriter decide whether to compress at the first write, so handlers
+// can still disable it by Response.DisableCompression, response already
+// has Content-Encoding will not be compressed again. Interim 1xx responses
+// don't make the decision, responses without body such as 204, 304 and HEAD
+// are never compressed
+type compressWriter struct {
 	http.ResponseWriter
+	resp      zerver.Response
+	encoding  string
 	needClose bool
-}
+	noBody    bool // request don't need body such as HEAD
 
-func (w gzipWriter) Write(data []byte) (int, error) {
-	return w.gw.Write(data)
+	decided bool
+	cw      io.WriteCloser // nil if not compressed
 }
 
-func (w gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	hijacker, is := w.ResponseWriter.(http.Hijacker)
-	if !is {
-		return nil, nil, zerver.ErrHijack
+func (w *compressWriter) decide(status int) {
+	if w.decided {
+		return
 	}
+	w.decided = true
 
-	w.gw.Close()
+	if w.noBody || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	headers := w.ResponseWriter.Header()
+	if w.resp.CompressionDisabled() || headers.Get(zerver.HEADER_CONTENTENCODING) != "" {
+		return
+	}
 
-	return hijacker.Hijack()
+	headers.Set(zerver.HEADER_CONTENTENCODING, w.encoding)
+	headers.Del(zerver.HEADER_CONTENTLENGTH)
+	if w.encoding == zerver.ENCODING_GZIP {
+		w.cw = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.cw, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+	}
 }
 
-func (w gzipWriter) Close() error {
-	err := w.gw.Close()
-	if w.needClose {
-		_ = w.ResponseWriter.(io.Closer).Close()
+func (w *compressWriter) WriteHeader(status int) {
+	if status >= http.StatusOK {
+		w.decide(status)
 	}
-
-	return err
+	w.ResponseWriter.WriteHeader(status)
 }
 
-type flateWriter struct {
-	fw *flate.Writer
-	http.ResponseWriter
-	needClose bool
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.decide(http.StatusOK)
+	if w.cw == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.cw.Write(data)
 }
 
-func (w flateWriter) Write(data []byte) (int, error) {
-	return w.fw.Write(data)
+func (w *compressWriter) Flush() {
+	if flusher, is := w.cw.(interface {
+		Flush() error
+	}); is {
+		flusher.Flush()
+	}
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
 }
 
-func (w flateWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, is := w.ResponseWriter.(http.Hijacker)
 	if !is {
 		return nil, nil, zerver.ErrHijack
 	}
 
-	w.fw.Close()
+	if w.cw != nil {
+		w.cw.Close()
+	}
 
 	return hijacker.Hijack()
 }
 
-func (w flateWriter) Close() error {
-	err := w.fw.Close()
+func (w *compressWriter) Close() error {
+	var err error
+	if w.cw != nil {
+		err = w.cw.Close()
+	}
 	if w.needClose {
 		_ = w.ResponseWriter.(io.Closer).Close()
 	}
@@ -72,39 +102,27 @@ func (w flateWriter) Close() error {
 	return err
 }
 
-func gzipWrapper(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
-	return gzipWriter{
-		gw:             gzip.NewWriter(w),
-		ResponseWriter: w,
-		needClose:      needClose,
-	}, true
-}
-
-func flateWrapper(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
-	fw, _ := flate.NewWriter(w, flate.DefaultCompression)
-
-	return flateWriter{
-		fw:             fw,
-		ResponseWriter: w,
-		needClose:      needClose,
-	}, true
-}
-
+// Compress compress response by gzip or deflate if client accept, the decision
+// is delayed until response is written, see Response.DisableCompression
 func Compress(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
 	encoding := req.GetHeader(zerver.HEADER_ACCEPTENCODING)
-
-	respHeaders := resp.Headers()
 	if strings.Contains(encoding, zerver.ENCODING_GZIP) {
-		respHeaders.Set(zerver.HEADER_CONTENTENCODING, zerver.ENCODING_GZIP)
-		resp.Wrap(gzipWrapper)
+		encoding = zerver.ENCODING_GZIP
 	} else if strings.Contains(encoding, zerver.ENCODING_DEFLATE) {
-		respHeaders.Set(zerver.HEADER_CONTENTENCODING, zerver.ENCODING_DEFLATE)
-		resp.Wrap(flateWrapper)
+		encoding = zerver.ENCODING_DEFLATE
 	} else {
 		chain(req, resp)
 		return
 	}
 
+	resp.Wrap(func(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
+		return &compressWriter{
+			ResponseWriter: w,
+			resp:           resp,
+			encoding:       encoding,
+			needClose:      needClose,
+			noBody:         !req.BodyNeeded(),
+		}, true
+	})
 	chain(req, resp)
-	respHeaders.Del(zerver.HEADER_CONTENTLENGTH)
 }
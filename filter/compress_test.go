@@ -0,0 +1,49 @@
+package filter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestCompress(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		cont     bool // send "100 Continue", then disable compression
+		encoding string
+	}{
+		{"ok", "GET", http.StatusOK, false, zerver.ENCODING_GZIP},
+		{"continue then disabled", "POST", http.StatusOK, true, ""},
+		{"no content", "GET", http.StatusNoContent, false, ""},
+		{"not modified", "GET", http.StatusNotModified, false, ""},
+		{"head", "HEAD", http.StatusOK, false, ""},
+	}
+
+	for _, tt := range tests {
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				if tt.cont {
+					resp.WriteContinue()
+					resp.DisableCompression()
+				}
+				resp.StatusCode(tt.status)
+				if tt.status == http.StatusOK {
+					resp.Write([]byte("body"))
+				}
+			}
+		})
+		h := zerver.HandlerToHTTP(handler, zerver.FilterFunc(Compress))
+
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.Header.Set(zerver.HEADER_ACCEPTENCODING, "gzip, deflate")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got := w.Header().Get(zerver.HEADER_CONTENTENCODING); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding: want %q, got %q", tt.name, tt.encoding, got)
+		}
+	}
+}
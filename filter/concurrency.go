@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/cosiner/gohper/net2/http2"
+	"github.com/cosiner/zerver"
+)
+
+// PerClientConcurrency limit in-flight requests of each client, exceeded
+// requests are rejected with 429. Counters of clients are removed once they
+// have no in-flight request, so idle clients take no memory.
+type PerClientConcurrency struct {
+	// max in-flight requests of a client
+	Max int
+	// key to identify a client, default use client ip, empty key is not
+	// limited
+	Key func(zerver.Request) string
+
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+func PerClientConcurrencyFilter(max int, keyFn func(zerver.Request) string) zerver.Filter {
+	return &PerClientConcurrency{
+		Max: max,
+		Key: keyFn,
+	}
+}
+
+func (c *PerClientConcurrency) Init(zerver.Env) error {
+	if c.Key == nil {
+		c.Key = func(req zerver.Request) string {
+			return http2.IpOfAddr(req.RemoteAddr())
+		}
+	}
+	c.inflight = make(map[string]int)
+	return nil
+}
+
+func (c *PerClientConcurrency) Destroy() {}
+
+func (c *PerClientConcurrency) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	key := c.Key(req)
+	if c.Max <= 0 || key == "" {
+		chain(req, resp)
+		return
+	}
+
+	if !c.acquire(key) {
+		resp.StatusCode(http.StatusTooManyRequests)
+		return
+	}
+	defer c.release(key)
+
+	chain(req, resp)
+}
+
+func (c *PerClientConcurrency) acquire(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.inflight[key]
+	if n >= c.Max {
+		return false
+	}
+	c.inflight[key] = n + 1
+	return true
+}
+
+func (c *PerClientConcurrency) release(key string) {
+	c.mu.Lock()
+	if n := c.inflight[key] - 1; n > 0 {
+		c.inflight[key] = n
+	} else {
+		delete(c.inflight, key)
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestPerClientConcurrency(t *testing.T) {
+	tests := []struct {
+		name   string
+		client string
+		code   int
+	}{
+		{"limited", "a", http.StatusTooManyRequests},
+		{"other client", "b", http.StatusOK},
+		{"not limited", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		var (
+			entered = make(chan struct{}, 2)
+			release = make(chan struct{})
+		)
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				if req.GetHeader("X-Block") != "" {
+					entered <- struct{}{}
+					<-release
+				}
+			}
+		})
+		f := &PerClientConcurrency{
+			Max: 2,
+			Key: func(req zerver.Request) string {
+				return req.GetHeader("X-Client")
+			},
+		}
+		f.Init(nil)
+		h := zerver.HandlerToHTTP(handler, f)
+		serve := func(client string, block bool) int {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Client", client)
+			if block {
+				req.Header.Set("X-Block", "1")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		// client "a" reach the limit
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serve("a", true)
+			}()
+			<-entered
+		}
+		if code := serve(tt.client, false); code != tt.code {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.code, code)
+		}
+		close(release)
+		wg.Wait()
+
+		f.mu.Lock()
+		n := len(f.inflight)
+		f.mu.Unlock()
+		if n != 0 {
+			t.Errorf("%s: counters are not removed on release: %v", tt.name, f.inflight)
+		}
+		if code := serve("a", false); code != http.StatusOK {
+			t.Errorf("%s: after release: want 200, got %d", tt.name, code)
+		}
+	}
+}
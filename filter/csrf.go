@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/cosiner/gohper/utils/defval"
+	log "github.com/cosiner/ygo/jsonlog"
+	"github.com/cosiner/zerver"
+)
+
+const _CSRF_ATTR = "filter.csrf.token"
+
+type (
+	// CSRFConfig config the double-submit-cookie csrf filter: a random token is
+	// issued in cookie, requests with unsafe methods must submit the same token
+	// in header or form field.
+	CSRFConfig struct {
+		// default "_csrf"
+		CookieName string
+		// default "X-CSRF-Token"
+		HeaderName string
+		// default "_csrf"
+		FormField string
+		// cookie attributes, default path is "/", 0 MaxAge means session cookie.
+		// the cookie is not HttpOnly, so javascript can read and submit it in header
+		Path   string
+		Domain string
+		MaxAge time.Duration
+		Secure bool
+		// random bytes of token, default 32
+		TokenBytes int
+		// requests which will not be verified, such as webhooks
+		Exclude func(zerver.Request) bool
+	}
+
+	csrfFilter struct {
+		cfg CSRFConfig
+	}
+)
+
+// CSRFFilter reject requests with unsafe methods whose csrf token is missing
+// or mismatch with 403
+func CSRFFilter(cfg CSRFConfig) zerver.Filter {
+	return &csrfFilter{cfg: cfg}
+}
+
+// CSRFToken return csrf token of current request, it can be rendered into
+// forms or templates
+func CSRFToken(req zerver.Request) string {
+	tok, _ := req.Attr(_CSRF_ATTR).(string)
+	return tok
+}
+
+func (c *csrfFilter) Init(zerver.Env) error {
+	defval.String(&c.cfg.CookieName, "_csrf")
+	defval.String(&c.cfg.HeaderName, "X-CSRF-Token")
+	defval.String(&c.cfg.FormField, "_csrf")
+	defval.String(&c.cfg.Path, "/")
+	defval.Int(&c.cfg.TokenBytes, 32)
+	return nil
+}
+
+func (c *csrfFilter) Destroy() {}
+
+func (c *csrfFilter) newToken() (string, error) {
+	b := make([]byte, c.cfg.TokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (c *csrfFilter) setCookie(resp zerver.Response, token string) {
+	cookie := http.Cookie{
+		Name:   c.cfg.CookieName,
+		Value:  token,
+		Path:   c.cfg.Path,
+		Domain: c.cfg.Domain,
+		MaxAge: int(c.cfg.MaxAge / time.Second),
+		Secure: c.cfg.Secure,
+	}
+	resp.Headers().Add(zerver.HEADER_SETCOOKIE, cookie.String())
+}
+
+func isSafeMethod(method string) bool {
+	return method == zerver.METHOD_GET || method == zerver.METHOD_HEAD ||
+		method == zerver.METHOD_OPTIONS || method == "TRACE"
+}
+
+func (c *csrfFilter) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	var token string
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		if cookie, err := r.Cookie(c.cfg.CookieName); err == nil {
+			token = cookie.Value
+		}
+		return r, needClose
+	})
+
+	issued := token == ""
+	if issued {
+		var err error
+		if token, err = c.newToken(); err != nil {
+			resp.Logger().Error(log.M{"msg": "generate csrf token failed", "err": err.Error()})
+			resp.StatusCode(http.StatusInternalServerError)
+			return
+		}
+		c.setCookie(resp, token)
+	}
+	req.SetAttr(_CSRF_ATTR, token)
+
+	if isSafeMethod(req.ReqMethod()) || (c.cfg.Exclude != nil && c.cfg.Exclude(req)) {
+		chain(req, resp)
+		return
+	}
+
+	submitted := req.GetHeader(c.cfg.HeaderName)
+	if submitted == "" {
+		submitted = req.Vars().FormVar(c.cfg.FormField)
+	}
+	if issued || submitted == "" ||
+		subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		resp.StatusCode(http.StatusForbidden)
+		return
+	}
+
+	chain(req, resp)
+}
@@ -0,0 +1,76 @@
+package filter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestCSRF(t *testing.T) {
+	const token = "token"
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		form   string
+		path   string
+		code   int
+	}{
+		{"safe method", "GET", "", "", "", "/", http.StatusOK},
+		{"head", "HEAD", token, "", "", "/", http.StatusOK},
+		{"no cookie", "POST", "", token, "", "/", http.StatusForbidden},
+		{"header", "POST", token, token, "", "/", http.StatusOK},
+		{"form", "POST", token, "", token, "/", http.StatusOK},
+		{"mismatch", "POST", token, "other", "", "/", http.StatusForbidden},
+		{"form mismatch", "POST", token, "", "other", "/", http.StatusForbidden},
+		{"missing", "POST", token, "", "", "/", http.StatusForbidden},
+		{"excluded", "POST", "", "", "", "/hook", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		var seen string
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				seen = CSRFToken(req)
+			}
+		})
+		f := CSRFFilter(CSRFConfig{
+			Exclude: func(req zerver.Request) bool {
+				return req.URL().Path == "/hook"
+			},
+		})
+		f.Init(nil)
+		h := zerver.HandlerToHTTP(handler, f)
+
+		var req *http.Request
+		if tt.form != "" {
+			req = httptest.NewRequest(tt.method, tt.path, strings.NewReader("_csrf="+tt.form))
+			req.Header.Set(zerver.HEADER_CONTENTTYPE, "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(tt.method, tt.path, nil)
+		}
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "_csrf", Value: tt.cookie})
+		}
+		if tt.header != "" {
+			req.Header.Set("X-CSRF-Token", tt.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.code, w.Code)
+		}
+		issued := w.Header().Get(zerver.HEADER_SETCOOKIE)
+		if (tt.cookie == "") != strings.HasPrefix(issued, "_csrf=") {
+			t.Errorf("%s: token cookie issued %q", tt.name, issued)
+		}
+		if w.Code == http.StatusOK && tt.cookie != "" && seen != tt.cookie {
+			t.Errorf("%s: CSRFToken: want %q, got %q", tt.name, tt.cookie, seen)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cosiner/zerver"
+)
+
+const (
+	HEADER_REQUESTDEADLINE = "X-Request-Deadline"
+	HEADER_GRPCTIMEOUT     = "Grpc-Timeout"
+)
+
+// Deadline set deadline of Request.Context() from request header for deadline
+// propagation across services. "X-Request-Deadline" is the absolute unix time
+// in milliseconds, "grpc-timeout" is the relative timeout such as "100m", if
+// both present, the earlier one is used. Requests whose deadline is already
+// passed are rejected with 504.
+type Deadline struct {
+	// max duration from now, later deadlines are capped to it, 0 means no cap
+	Max time.Duration
+}
+
+func (d *Deadline) Init(zerver.Env) error { return nil }
+
+func (d *Deadline) Destroy() {}
+
+func (d *Deadline) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	now := time.Now()
+	deadline, ok := parseDeadline(req, now)
+	if !ok {
+		chain(req, resp)
+		return
+	}
+	if d.Max > 0 && deadline.Sub(now) > d.Max {
+		deadline = now.Add(d.Max)
+	}
+	if !deadline.After(now) {
+		resp.StatusCode(http.StatusGatewayTimeout)
+		return
+	}
+
+	var cancel context.CancelFunc
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		var ctx context.Context
+		ctx, cancel = context.WithDeadline(r.Context(), deadline)
+		return r.WithContext(ctx), needClose
+	})
+	defer cancel()
+
+	chain(req, resp)
+}
+
+func parseDeadline(req zerver.Request, now time.Time) (deadline time.Time, ok bool) {
+	if h := req.GetHeader(HEADER_REQUESTDEADLINE); h != "" {
+		if ms, err := strconv.ParseInt(strings.TrimSpace(h), 10, 64); err == nil {
+			deadline, ok = time.Unix(0, ms*int64(time.Millisecond)), true
+		}
+	}
+	if h := req.GetHeader(HEADER_GRPCTIMEOUT); h != "" {
+		if timeout, is := parseGRPCTimeout(strings.TrimSpace(h)); is {
+			if t := now.Add(timeout); !ok || t.Before(deadline) {
+				deadline, ok = t, true
+			}
+		}
+	}
+	return deadline, ok
+}
+
+// parseGRPCTimeout parse timeout like "100m", at most 8 digits followed by a
+// unit of H, M, S, m(milli), u(micro) or n(nano)
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestParseGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		s       string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"1H", time.Hour, true},
+		{"2M", 2 * time.Minute, true},
+		{"3S", 3 * time.Second, true},
+		{"100m", 100 * time.Millisecond, true},
+		{"5u", 5 * time.Microsecond, true},
+		{"7n", 7 * time.Nanosecond, true},
+		{"99999999S", 99999999 * time.Second, true},
+		{"0m", 0, true},
+		{"123456789S", 0, false}, // more than 8 digits
+		{"S", 0, false},
+		{"10", 0, false},
+		{"10s", 0, false},
+		{"-1S", 0, false},
+		{"+1S", 0, false},
+		{"1.5S", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		timeout, ok := parseGRPCTimeout(tt.s)
+		if timeout != tt.timeout || ok != tt.ok {
+			t.Errorf("%q: got %v %t, expect %v %t", tt.s, timeout, ok, tt.timeout, tt.ok)
+		}
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	now := time.Now()
+	ms := func(t time.Time) string {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	tests := []struct {
+		header http.Header
+		max    time.Duration
+		status int
+		// expected remaining time, 0 means no deadline
+		remain time.Duration
+	}{
+		{nil, 0, http.StatusOK, 0},
+		{http.Header{"Grpc-Timeout": {"10S"}}, 0, http.StatusOK, 10 * time.Second},
+		{http.Header{"X-Request-Deadline": {ms(now.Add(time.Minute))}}, 0, http.StatusOK, time.Minute},
+		{http.Header{"X-Request-Deadline": {ms(now.Add(time.Minute))}, "Grpc-Timeout": {"10S"}}, 0, http.StatusOK, 10 * time.Second},
+		{http.Header{"Grpc-Timeout": {"1H"}}, time.Second, http.StatusOK, time.Second},
+		{http.Header{"X-Request-Deadline": {ms(now.Add(-time.Second))}}, 0, http.StatusGatewayTimeout, 0},
+		{http.Header{"Grpc-Timeout": {"bad"}}, 0, http.StatusOK, 0},
+	}
+	for i, tt := range tests {
+		var (
+			remain time.Duration
+			has    bool
+		)
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				var deadline time.Time
+				if deadline, has = req.Context().Deadline(); has {
+					remain = time.Until(deadline)
+				}
+			}
+		})
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		zerver.HandlerToHTTP(handler, &Deadline{Max: tt.max}).ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%d: status %d, expect %d", i, w.Code, tt.status)
+			continue
+		}
+		if has != (tt.remain != 0) || remain > tt.remain || remain < tt.remain-time.Second {
+			t.Errorf("%d: remain %v(has deadline %t), expect %v", i, remain, has, tt.remain)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cosiner/gohper/errors"
+	"github.com/cosiner/zerver"
+)
+
+const (
+	ErrDecompressedTooLarge = errors.Err("decompressed request body too large")
+
+	_DEF_MAX_DECOMPRESSED = 10 << 20 // 10M, if server body size is unlimited
+)
+
+// Decompress decompress request body if Content-Encoding is gzip or deflate,
+// so handlers and Request.Receive see the plaintext. Request with invalid
+// compressed body will be rejected with 400, request whose decompressed body
+// exceed MaxDecompressedBytes will be rejected with 413 if handler haven't
+// written anything.
+//
+// Notice: form body is parsed before filters, it's not affected.
+type Decompress struct {
+	// max bytes of decompressed body, reading more will get ErrDecompressedTooLarge,
+	// it guard against decompression bombs and can't be disabled, default
+	// ServerOption.MaxBodyBytes, or 10M if it's unlimited
+	MaxDecompressedBytes int64
+}
+
+type decompressBody struct {
+	io.Reader
+	decompressor io.Closer
+	body         io.Closer
+}
+
+func (b decompressBody) Close() error {
+	err := b.decompressor.Close()
+	if e := b.body.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// limitedReader is similar as io.LimitedReader, but return an error instead of
+// io.EOF if there is more data
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrDecompressedTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, err
+	}
+
+	n, l.exceeded = int(l.n), true
+	return n, ErrDecompressedTooLarge
+}
+
+// isZlib check the zlib header: compression method 8 and the header checksum
+func isZlib(r *bufio.Reader) bool {
+	h, err := r.Peek(2)
+	return err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
+
+func (d *Decompress) Init(env zerver.Env) error {
+	if d.MaxDecompressedBytes <= 0 && env != nil {
+		d.MaxDecompressedBytes = env.Server().Options().MaxBodyBytes
+	}
+	if d.MaxDecompressedBytes <= 0 {
+		d.MaxDecompressedBytes = _DEF_MAX_DECOMPRESSED
+	}
+	return nil
+}
+
+func (d *Decompress) Destroy() {}
+
+func (d *Decompress) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	encoding := strings.ToLower(strings.TrimSpace(req.GetHeader(zerver.HEADER_CONTENTENCODING)))
+	if encoding != zerver.ENCODING_GZIP && encoding != zerver.ENCODING_DEFLATE {
+		chain(req, resp)
+		return
+	}
+
+	var (
+		err     error
+		limited *limitedReader
+	)
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		if r.Body == nil {
+			return r, needClose
+		}
+
+		var dec io.ReadCloser
+		if encoding == zerver.ENCODING_GZIP {
+			dec, err = gzip.NewReader(r.Body)
+			if err != nil {
+				return r, needClose
+			}
+		} else {
+			// deflate is zlib format, some clients send raw deflate
+			br := bufio.NewReader(r.Body)
+			if isZlib(br) {
+				dec, err = zlib.NewReader(br)
+				if err != nil {
+					return r, needClose
+				}
+			} else {
+				dec = flate.NewReader(br)
+			}
+		}
+
+		limited = &limitedReader{r: dec, n: d.MaxDecompressedBytes}
+		r.Body = decompressBody{
+			Reader:       limited,
+			decompressor: dec,
+			body:         r.Body,
+		}
+		r.ContentLength = -1
+		r.Header.Del(zerver.HEADER_CONTENTENCODING)
+		r.Header.Del(zerver.HEADER_CONTENTLENGTH)
+		return r, needClose
+	})
+
+	if err != nil {
+		resp.StatusCode(http.StatusBadRequest)
+		return
+	}
+	chain(req, resp)
+
+	if limited != nil && limited.exceeded && !resp.Written() {
+		resp.StatusCode(http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,102 @@
+package filter
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestDecompressMaxBytes(t *testing.T) {
+	tests := []struct {
+		max, maxBody, expect int64
+	}{
+		{0, 0, _DEF_MAX_DECOMPRESSED},
+		{0, 1 << 10, 1 << 10},
+		{100, 1 << 10, 100},
+	}
+	for _, tt := range tests {
+		d := &Decompress{MaxDecompressedBytes: tt.max}
+		s := zerver.NewServer("")
+		s.Filter("/", d)
+
+		listening := make(chan struct{})
+		go s.Start(&zerver.ServerOption{
+			ListenAddr:   "127.0.0.1:0",
+			MaxBodyBytes: tt.maxBody,
+			ListenerWrapper: func(l net.Listener) net.Listener {
+				close(listening) // filters are initialized before listen
+				return l
+			},
+		})
+		select {
+		case <-listening:
+		case <-time.After(5 * time.Second):
+			t.Fatal("server not started")
+		}
+		for !s.IsRunning() {
+			time.Sleep(time.Millisecond)
+		}
+		s.Destroy(time.Second)
+		if d.MaxDecompressedBytes != tt.expect {
+			t.Errorf("max %d, server %d: want %d, got %d", tt.max, tt.maxBody, tt.expect, d.MaxDecompressedBytes)
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	compress := func(fn func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := fn(&buf)
+		w.Write([]byte("hello"))
+		w.Close()
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		code     int
+	}{
+		{"gzip", "gzip", compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }), http.StatusOK},
+		{"deflate", "deflate", compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }), http.StatusOK},
+		{"raw deflate", "deflate", compress(func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}), http.StatusOK},
+		{"invalid gzip", "gzip", []byte("hello"), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				body, err := ioutil.ReadAll(req)
+				if err != nil {
+					resp.StatusCode(http.StatusInternalServerError)
+					return
+				}
+				resp.Write(body)
+			}
+		})
+		d := &Decompress{}
+		d.Init(nil)
+		h := zerver.HandlerToHTTP(handler, d)
+
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(tt.body))
+		req.Header.Set(zerver.HEADER_CONTENTENCODING, tt.encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code || (tt.code == http.StatusOK && w.Body.String() != "hello") {
+			t.Errorf("%s: got %d %q", tt.name, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/cosiner/ygo/jsonlog"
+	"github.com/cosiner/zerver"
+)
+
+type (
+	DumpOptions struct {
+		// max body bytes will be dumped for both request and response, default 4K,
+		// negative means don't dump body
+		MaxBody int
+		// only body of these content types will be dumped, prefix matched, default
+		// text/*, json, xml and form
+		BodyTypes []string
+	}
+
+	dumpFilter struct {
+		w   io.Writer
+		mu  sync.Mutex
+		opt DumpOptions
+		log *log.Logger
+	}
+
+	dumpWriter struct {
+		http.ResponseWriter
+		body      bytes.Buffer
+		max       int
+		needClose bool
+	}
+
+	bodyReadCloser struct {
+		io.Reader
+		io.Closer
+	}
+)
+
+var defDumpBodyTypes = []string{
+	"text/",
+	"application/json",
+	"application/xml",
+	"application/x-www-form-urlencoded",
+}
+
+// DumpFilter dump raw request line, headers and body, and response status,
+// headers, body to w, it's designed for debugging.
+//
+// The request body is restored after read, so handlers can still read it, but
+// the form body already parsed by server is not dumped.
+func DumpFilter(w io.Writer, opts DumpOptions) zerver.Filter {
+	return &dumpFilter{
+		w:   w,
+		opt: opts,
+	}
+}
+
+func (d *dumpFilter) Init(zerver.Env) error {
+	if d.opt.MaxBody == 0 {
+		d.opt.MaxBody = 4 * 1024
+	}
+	if d.opt.BodyTypes == nil {
+		d.opt.BodyTypes = defDumpBodyTypes
+	}
+	d.log = log.Derive("Filter", "Dump")
+	return nil
+}
+
+func (d *dumpFilter) Destroy() {}
+
+func (d *dumpFilter) dumpBody(typ string) bool {
+	if d.opt.MaxBody < 0 {
+		return false
+	}
+
+	for _, t := range d.opt.BodyTypes {
+		if strings.HasPrefix(typ, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dumpFilter) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		buf.WriteString("> " + r.Method + " " + r.URL.RequestURI() + " " + r.Proto + "\n")
+		writeHeaders(buf, "> ", r.Header)
+
+		if r.Body != nil && d.dumpBody(r.Header.Get(zerver.HEADER_CONTENTTYPE)) {
+			body := make([]byte, d.opt.MaxBody)
+			n, _ := io.ReadFull(r.Body, body)
+			body = body[:n]
+			writeBody(buf, "> ", body)
+
+			r.Body = bodyReadCloser{
+				Reader: io.MultiReader(bytes.NewReader(body), r.Body),
+				Closer: r.Body,
+			}
+		}
+		return r, needClose
+	})
+
+	dw := &dumpWriter{max: d.opt.MaxBody}
+	resp.Wrap(func(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
+		dw.ResponseWriter = w
+		dw.needClose = needClose
+		return dw, true
+	})
+
+	chain(req, resp)
+
+	status := resp.StatusCode(0)
+	buf.WriteString("< " + strconv.Itoa(status) + " " + http.StatusText(status) + "\n")
+	headers := resp.Headers()
+	writeHeaders(buf, "< ", headers)
+	if d.dumpBody(headers.Get(zerver.HEADER_CONTENTTYPE)) {
+		writeBody(buf, "< ", dw.body.Bytes())
+	}
+
+	d.mu.Lock()
+	_, err := d.w.Write(buf.Bytes())
+	d.mu.Unlock()
+	if err != nil {
+		d.log.Warn(log.M{"msg": "write dump failed", "err": err.Error()})
+	}
+}
+
+func writeHeaders(buf *bytes.Buffer, prefix string, headers http.Header) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range headers[name] {
+			buf.WriteString(prefix + name + ": " + v + "\n")
+		}
+	}
+	buf.WriteString(prefix + "\n")
+}
+
+func writeBody(buf *bytes.Buffer, prefix string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+
+	buf.WriteString(prefix)
+	buf.Write(body)
+	buf.WriteString("\n")
+}
+
+func (w *dumpWriter) Write(data []byte) (int, error) {
+	if remain := w.max - w.body.Len(); remain > 0 {
+		if remain > len(data) {
+			remain = len(data)
+		}
+		w.body.Write(data[:remain])
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *dumpWriter) Flush() {
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+func (w *dumpWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, is := w.ResponseWriter.(http.Hijacker)
+	if !is {
+		return nil, nil, zerver.ErrHijack
+	}
+
+	return hijacker.Hijack()
+}
+
+func (w *dumpWriter) Close() error {
+	if w.needClose {
+		return w.ResponseWriter.(io.Closer).Close()
+	}
+	return nil
+}
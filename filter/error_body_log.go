@@ -0,0 +1,166 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	log "github.com/cosiner/ygo/jsonlog"
+	"github.com/cosiner/zerver"
+)
+
+type (
+	// ErrorBodyLog capture request body while it's read by handler, and log it
+	// only if response status is 5xx, the capture is discarded for others.
+	//
+	// Form body is already parsed by server before filters, so the parsed
+	// Request.PostForm is logged url-encoded for form requests instead.
+	ErrorBodyLog struct {
+		// max bytes of body captured, default 4K
+		MaxBody int
+		// values of these fields in json body is replaced with "***" at any
+		// depth, body isn't logged if it's not valid json or it's truncated.
+		// For form body, values of these fields are replaced
+		RedactFields []string
+		// custom redaction, if setted, RedactFields is ignored, for form body
+		// it's called with the url-encoded form
+		Redact func(body []byte) []byte
+
+		redactFields map[string]bool
+		log          *log.Logger
+	}
+
+	// captureBody copy at most max bytes read from body
+	captureBody struct {
+		io.ReadCloser
+		buf       bytes.Buffer
+		max       int
+		truncated bool
+	}
+)
+
+func (e *ErrorBodyLog) Init(zerver.Env) error {
+	if e.MaxBody <= 0 {
+		e.MaxBody = 4 * 1024
+	}
+	if len(e.RedactFields) != 0 {
+		e.redactFields = make(map[string]bool, len(e.RedactFields))
+		for _, f := range e.RedactFields {
+			e.redactFields[f] = true
+		}
+	}
+	e.log = log.Derive("Filter", "ErrorBodyLog")
+	return nil
+}
+
+func (e *ErrorBodyLog) Destroy() {}
+
+func (e *ErrorBodyLog) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	var (
+		body    *captureBody
+		request *http.Request
+	)
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		if r.Body != nil {
+			body = &captureBody{ReadCloser: r.Body, max: e.MaxBody}
+			r.Body = body
+		}
+		request = r
+		return r, needClose
+	})
+
+	chain(req, resp)
+
+	status := resp.StatusCode(0)
+	if body == nil || status < http.StatusInternalServerError {
+		return
+	}
+	data, truncated := body.buf.Bytes(), body.truncated
+	if len(data) == 0 && len(request.PostForm) != 0 {
+		data = e.redactForm(request.PostForm)
+		if truncated = len(data) > e.MaxBody; truncated {
+			data = data[:e.MaxBody]
+		}
+	} else {
+		data = e.redact(data, truncated)
+	}
+	e.log.Warn(log.M{
+		"msg":       "request failed",
+		"method":    req.ReqMethod(),
+		"url":       req.URL().String(),
+		"status":    status,
+		"body":      string(data),
+		"truncated": truncated,
+	})
+}
+
+// redactForm encode form values with RedactFields replaced
+func (e *ErrorBodyLog) redactForm(form url.Values) []byte {
+	if e.Redact != nil {
+		return e.Redact([]byte(form.Encode()))
+	}
+	if len(e.redactFields) == 0 {
+		return []byte(form.Encode())
+	}
+
+	redacted := make(url.Values, len(form))
+	for k, vals := range form {
+		if e.redactFields[k] {
+			vals = []string{"***"}
+		}
+		redacted[k] = vals
+	}
+	return []byte(redacted.Encode())
+}
+
+func (e *ErrorBodyLog) redact(body []byte, truncated bool) []byte {
+	if e.Redact != nil {
+		return e.Redact(body)
+	}
+	if len(e.redactFields) == 0 {
+		return body
+	}
+
+	var v interface{}
+	if truncated || json.Unmarshal(body, &v) != nil {
+		return []byte("<unredactable body omitted>")
+	}
+	data, err := json.Marshal(e.redactValue(v))
+	if err != nil {
+		return []byte("<unredactable body omitted>")
+	}
+	return data
+}
+
+func (e *ErrorBodyLog) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if e.redactFields[k] {
+				v[k] = "***"
+			} else {
+				v[k] = e.redactValue(val)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = e.redactValue(v[i])
+		}
+	}
+	return v
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if remain := b.max - b.buf.Len(); remain >= n {
+			b.buf.Write(p[:n])
+		} else {
+			b.buf.Write(p[:remain])
+			b.truncated = true
+		}
+	}
+	return n, err
+}
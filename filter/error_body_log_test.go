@@ -0,0 +1,31 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestErrorBodyLogRedact(t *testing.T) {
+	e := &ErrorBodyLog{RedactFields: []string{"password"}}
+	e.Init(nil)
+
+	tests := []struct {
+		body, expect string
+		truncated    bool
+	}{
+		{`{"name":"a","password":"x"}`, `{"name":"a","password":"***"}`, false},
+		{`[{"user":{"password":"x"}}]`, `[{"user":{"password":"***"}}]`, false},
+		{`{"password":"x"`, "<unredactable body omitted>", false},
+		{`{"password":"x"}`, "<unredactable body omitted>", true},
+	}
+	for _, tt := range tests {
+		if got := string(e.redact([]byte(tt.body), tt.truncated)); got != tt.expect {
+			t.Errorf("%s: want %s, got %s", tt.body, tt.expect, got)
+		}
+	}
+
+	form := url.Values{"name": {"a"}, "password": {"x", "y"}}
+	if got, expect := string(e.redactForm(form)), "name=a&password=%2A%2A%2A"; got != expect {
+		t.Errorf("form: want %s, got %s", expect, got)
+	}
+}
@@ -0,0 +1,368 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cosiner/gohper/errors"
+	"github.com/cosiner/zerver"
+)
+
+const (
+	ErrInvalidSchema = errors.Err("invalid json schema")
+)
+
+type (
+	// SchemaValidator validate a decoded json document, numbers in it are
+	// json.Number, nil or empty result means valid
+	SchemaValidator interface {
+		Validate(doc interface{}) []string
+	}
+
+	// SchemaCompiler compile a json schema into validator, it's used to plug
+	// other json schema implementations
+	SchemaCompiler func(schema []byte) (SchemaValidator, error)
+
+	// JSONSchema validate request body against a json schema before handler,
+	// request with invalid body is rejected with 422 and the validation errors,
+	// malformed json is rejected with 400. Requests without body pass through.
+	JSONSchema struct {
+		Schema []byte
+		// default is the builtin one, it support a subset of draft-07: type,
+		// enum, required, properties, additionalProperties(boolean), items,
+		// minimum, maximum, minLength, maxLength, minItems, maxItems, pattern,
+		// and annotations such as title, description. Schemas use other
+		// keywords such as $ref, oneOf, format fail with ErrInvalidSchema,
+		// plug a full implementation for them.
+		Compiler SchemaCompiler
+
+		validator SchemaValidator
+	}
+
+	// schemaNode is a compiled schema of the builtin compiler
+	schemaNode struct {
+		types        []string
+		enum         []interface{}
+		required     []string
+		properties   map[string]*schemaNode
+		noAdditional bool
+		items        *schemaNode
+		minimum      *float64
+		maximum      *float64
+		minLength    int
+		maxLength    int
+		minItems     int
+		maxItems     int
+		pattern      *regexp.Regexp
+	}
+)
+
+func JSONSchemaFilter(schema []byte) zerver.Filter {
+	return &JSONSchema{
+		Schema: schema,
+	}
+}
+
+func (j *JSONSchema) Init(zerver.Env) (err error) {
+	if j.Compiler == nil {
+		j.Compiler = CompileJSONSchema
+	}
+	j.validator, err = j.Compiler(j.Schema)
+	return err
+}
+
+func (j *JSONSchema) Destroy() {}
+
+func (j *JSONSchema) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	var (
+		body    []byte
+		readErr error
+	)
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		if r.Body == nil {
+			return r, needClose
+		}
+
+		body, readErr = ioutil.ReadAll(r.Body)
+		r.Body = bodyReadCloser{
+			Reader: bytes.NewReader(body),
+			Closer: r.Body,
+		}
+		return r, needClose
+	})
+	if readErr != nil {
+		resp.StatusCode(http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		chain(req, resp)
+		return
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		resp.StatusCode(http.StatusBadRequest)
+		return
+	}
+
+	if errs := j.validator.Validate(doc); len(errs) != 0 {
+		resp.StatusCode(http.StatusUnprocessableEntity)
+		resp.Send(zerver.NewError(errs))
+		return
+	}
+	chain(req, resp)
+}
+
+// CompileJSONSchema is the builtin SchemaCompiler
+func CompileJSONSchema(schema []byte) (SchemaValidator, error) {
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(schema))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, ErrInvalidSchema
+	}
+	return compileSchemaNode(raw)
+}
+
+func compileSchemaNode(raw map[string]interface{}) (*schemaNode, error) {
+	n := &schemaNode{
+		minLength: -1,
+		maxLength: -1,
+		minItems:  -1,
+		maxItems:  -1,
+	}
+
+	for key, val := range raw {
+		var ok = true
+		switch key {
+		case "type":
+			switch t := val.(type) {
+			case string:
+				n.types = []string{t}
+			case []interface{}:
+				for _, v := range t {
+					s, is := v.(string)
+					ok = ok && is
+					n.types = append(n.types, s)
+				}
+			default:
+				ok = false
+			}
+		case "enum":
+			n.enum, ok = val.([]interface{})
+		case "required":
+			var list []interface{}
+			list, ok = val.([]interface{})
+			for _, v := range list {
+				s, is := v.(string)
+				ok = ok && is
+				n.required = append(n.required, s)
+			}
+		case "properties":
+			var props map[string]interface{}
+			if props, ok = val.(map[string]interface{}); ok {
+				n.properties = make(map[string]*schemaNode, len(props))
+				for name, p := range props {
+					pm, is := p.(map[string]interface{})
+					if !is {
+						return nil, ErrInvalidSchema
+					}
+					child, err := compileSchemaNode(pm)
+					if err != nil {
+						return nil, err
+					}
+					n.properties[name] = child
+				}
+			}
+		case "additionalProperties":
+			var allow bool
+			allow, ok = val.(bool)
+			n.noAdditional = !allow
+		case "items":
+			var im map[string]interface{}
+			if im, ok = val.(map[string]interface{}); ok {
+				child, err := compileSchemaNode(im)
+				if err != nil {
+					return nil, err
+				}
+				n.items = child
+			}
+		case "minimum", "maximum":
+			var f float64
+			if f, ok = schemaNumber(val); ok {
+				if key == "minimum" {
+					n.minimum = &f
+				} else {
+					n.maximum = &f
+				}
+			}
+		case "minLength", "maxLength", "minItems", "maxItems":
+			var f float64
+			if f, ok = schemaNumber(val); ok {
+				switch key {
+				case "minLength":
+					n.minLength = int(f)
+				case "maxLength":
+					n.maxLength = int(f)
+				case "minItems":
+					n.minItems = int(f)
+				default:
+					n.maxItems = int(f)
+				}
+			}
+		case "pattern":
+			var s string
+			if s, ok = val.(string); ok {
+				var err error
+				if n.pattern, err = regexp.Compile(s); err != nil {
+					ok = false
+				}
+			}
+		case "$schema", "$id", "$comment", "title", "description", "default", "examples", "readOnly", "writeOnly":
+			// annotations, they don't affect validation
+		default:
+			// unsupported keyword, ignoring it will enforce only part of schema
+			ok = false
+		}
+		if !ok {
+			return nil, ErrInvalidSchema
+		}
+	}
+	return n, nil
+}
+
+func schemaNumber(v interface{}) (float64, bool) {
+	num, is := v.(json.Number)
+	if !is {
+		return 0, false
+	}
+	f, err := num.Float64()
+	return f, err == nil
+}
+
+func schemaType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if !strings.ContainsAny(v.String(), ".eE") {
+			return "integer"
+		}
+		// number with zero fractional part such as 1.0 is also integer
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+func (n *schemaNode) Validate(doc interface{}) []string {
+	return n.validate("$", doc, nil)
+}
+
+func (n *schemaNode) validate(path string, v interface{}, errs []string) []string {
+	typ := schemaType(v)
+	if len(n.types) != 0 {
+		var matched bool
+		for _, t := range n.types {
+			if t == typ || (t == "number" && typ == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return append(errs, path+": must be "+strings.Join(n.types, " or "))
+		}
+	}
+
+	if len(n.enum) != 0 {
+		var matched bool
+		for _, e := range n.enum {
+			if reflect.DeepEqual(e, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, path+": must be one of enum values")
+		}
+	}
+
+	switch typ {
+	case "string":
+		s := v.(string)
+		l := utf8.RuneCountInString(s)
+		if n.minLength >= 0 && l < n.minLength {
+			errs = append(errs, fmt.Sprintf("%s: length must be >= %d", path, n.minLength))
+		}
+		if n.maxLength >= 0 && l > n.maxLength {
+			errs = append(errs, fmt.Sprintf("%s: length must be <= %d", path, n.maxLength))
+		}
+		if n.pattern != nil && !n.pattern.MatchString(s) {
+			errs = append(errs, path+": must match pattern "+n.pattern.String())
+		}
+	case "number", "integer":
+		f, _ := schemaNumber(v)
+		if n.minimum != nil && f < *n.minimum {
+			errs = append(errs, path+": must be >= "+strconv.FormatFloat(*n.minimum, 'g', -1, 64))
+		}
+		if n.maximum != nil && f > *n.maximum {
+			errs = append(errs, path+": must be <= "+strconv.FormatFloat(*n.maximum, 'g', -1, 64))
+		}
+	case "array":
+		items := v.([]interface{})
+		if n.minItems >= 0 && len(items) < n.minItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at least %d items", path, n.minItems))
+		}
+		if n.maxItems >= 0 && len(items) > n.maxItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at most %d items", path, n.maxItems))
+		}
+		if n.items != nil {
+			for i, item := range items {
+				errs = n.items.validate(path+"["+strconv.Itoa(i)+"]", item, errs)
+			}
+		}
+	case "object":
+		obj := v.(map[string]interface{})
+		for _, name := range n.required {
+			if _, has := obj[name]; !has {
+				errs = append(errs, path+"."+name+": is required")
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			val := obj[name]
+			if child := n.properties[name]; child != nil {
+				errs = child.validate(path+"."+name, val, errs)
+			} else if n.noAdditional {
+				errs = append(errs, path+"."+name+": is not allowed")
+			}
+		}
+	}
+	return errs
+}
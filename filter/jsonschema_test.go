@@ -0,0 +1,137 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+const testSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "user",
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 8, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"score": {"type": "number"},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}},
+		"note": {"type": ["string", "null"]}
+	}
+}`
+
+func TestCompileJSONSchema(t *testing.T) {
+	tests := []struct {
+		schema string
+		valid  bool
+	}{
+		{testSchema, true},
+		{`{}`, true},
+		{`{"description": "any", "default": 1, "examples": [1]}`, true},
+		{`[]`, false},
+		{`{"type": 1}`, false},
+		{`{"required": "name"}`, false},
+		{`{"properties": {"a": 1}}`, false},
+		{`{"minimum": "1"}`, false},
+		{`{"pattern": "("}`, false},
+		{`{"additionalProperties": {}}`, false},
+		// unsupported keywords must not be ignored
+		{`{"$ref": "#/definitions/a"}`, false},
+		{`{"oneOf": [{"type": "string"}]}`, false},
+		{`{"anyOf": [{"type": "string"}]}`, false},
+		{`{"const": 1}`, false},
+		{`{"format": "email"}`, false},
+		{`{"exclusiveMinimum": 1}`, false},
+		{`{"minProperties": 1}`, false},
+		{`{"properties": {"a": {"format": "date"}}}`, false},
+	}
+	for _, tt := range tests {
+		_, err := CompileJSONSchema([]byte(tt.schema))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: err %v, expect valid %t", tt.schema, err, tt.valid)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	v, err := CompileJSONSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc  string
+		errs []string
+	}{
+		{`{"name": "abc", "age": 1}`, nil},
+		{`{"name": "abc", "age": 1.0, "score": 1.5, "role": "user", "tags": ["a"], "note": null}`, nil},
+		{`{"name": "abc", "age": 1.5}`, []string{"$.age: must be integer"}},
+		{`{"name": "abc", "age": 1e2}`, nil},
+		{`{"name": "abc"}`, []string{"$.age: is required"}},
+		{`{"name": "a", "age": -1}`, []string{"$.age: must be >= 0", "$.name: length must be >= 2"}},
+		{`{"name": "ABC", "age": 200}`, []string{"$.age: must be <= 150", "$.name: must match pattern ^[a-z]+$"}},
+		{`{"name": "abc", "age": 1, "role": "root"}`, []string{"$.role: must be one of enum values"}},
+		{`{"name": "abc", "age": 1, "tags": []}`, []string{"$.tags: must have at least 1 items"}},
+		{`{"name": "abc", "age": 1, "tags": ["a", 1, "c"]}`, []string{"$.tags: must have at most 2 items", "$.tags[1]: must be string"}},
+		{`{"name": "abc", "age": 1, "other": 1}`, []string{"$.other: is not allowed"}},
+		{`{"name": "abc", "age": 1, "note": 1}`, []string{"$.note: must be string or null"}},
+		{`[]`, []string{"$: must be object"}},
+	}
+	for _, tt := range tests {
+		var doc interface{}
+		dec := json.NewDecoder(strings.NewReader(tt.doc))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			t.Fatal(tt.doc, err)
+		}
+
+		errs := v.Validate(doc)
+		if strings.Join(errs, "\n") != strings.Join(tt.errs, "\n") {
+			t.Errorf("%s: errors %q, expect %q", tt.doc, errs, tt.errs)
+		}
+	}
+}
+
+func TestJSONSchemaFilter(t *testing.T) {
+	var body string
+	handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+		return func(req zerver.Request, resp zerver.Response) {
+			var buf bytes.Buffer
+			buf.ReadFrom(req)
+			body = buf.String()
+		}
+	})
+	f := JSONSchemaFilter([]byte(testSchema))
+	if err := f.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	h := zerver.HandlerToHTTP(handler, f)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "abc", "age": 1}`, http.StatusOK},
+		{`{"name": "abc"}`, http.StatusUnprocessableEntity},
+		{`{"name": `, http.StatusBadRequest},
+		{``, http.StatusOK},
+	}
+	for _, tt := range tests {
+		body = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%q: status %d, expect %d", tt.body, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && body != tt.body {
+			t.Errorf("%q: handler read %q", tt.body, body)
+		}
+	}
+}
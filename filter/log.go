@@ -1,12 +1,19 @@
 package filter
 
 import (
+	"net/http"
+
 	"github.com/cosiner/gohper/time2"
 	log "github.com/cosiner/ygo/jsonlog"
 	"github.com/cosiner/zerver"
 )
 
 type Log struct {
+	// only log routes marked by Server.SetRouteLogging, others are logged
+	// briefly as before if false. Marked routes are always logged with
+	// request and response headers.
+	OnlyMarked bool
+
 	log *log.Logger
 }
 
@@ -16,18 +23,38 @@ func (l *Log) Init(env zerver.Env) error {
 }
 
 func (l *Log) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
-	now := time2.Now()
+	marked := req.Server().RouteLogging(req.Vars().Pattern())
+	if l.OnlyMarked && !marked {
+		chain(req, resp)
+		return
+	}
+
+	var reqHeaders http.Header
+	if marked {
+		req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+			reqHeaders = r.Header
+			return r, needClose
+		})
+	}
+
 	chain(req, resp)
-	cost := time2.Now().Sub(now)
+	cost := time2.Now().Sub(req.StartedAt())
 
-	l.log.Info(log.M{
+	m := log.M{
 		"method":     req.ReqMethod(),
 		"url":        req.URL().String(),
+		"proto":      req.Protocol(),
 		"remote":     req.RemoteAddr(),
 		"userAgent":  req.GetHeader(zerver.HEADER_USERAGENT),
 		"cost":       cost.String(),
 		"statusCode": resp.StatusCode(0),
-	})
+	}
+	if marked {
+		m["route"] = req.Vars().Pattern()
+		m["reqHeaders"] = reqHeaders
+		m["respHeaders"] = resp.Headers()
+	}
+	l.log.Info(m)
 }
 
 func (l *Log) Destroy() {}
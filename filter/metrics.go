@@ -0,0 +1,115 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cosiner/zerver"
+)
+
+type (
+	// Metrics collect request metrics, route is the registered pattern of
+	// matched route, it's empty if no route matched
+	Metrics interface {
+		ObserveRequest(route, method string, status int, cost time.Duration)
+		// bytes of request body
+		ObserveRequestSize(route string, bytes int)
+		// bytes of response body, before compressed
+		ObserveResponseSize(route string, bytes int)
+	}
+
+	NopMetrics struct{}
+
+	// MetricsFilter report metrics of each request, default use NopMetrics
+	MetricsFilter struct {
+		Metrics Metrics
+	}
+
+	countReader struct {
+		io.ReadCloser
+		n int
+	}
+
+	countWriter struct {
+		http.ResponseWriter
+		n         int
+		needClose bool
+	}
+)
+
+func (NopMetrics) ObserveRequest(string, string, int, time.Duration) {}
+func (NopMetrics) ObserveRequestSize(string, int)                    {}
+func (NopMetrics) ObserveResponseSize(string, int)                   {}
+
+func (m *MetricsFilter) Init(zerver.Env) error {
+	if m.Metrics == nil {
+		m.Metrics = NopMetrics{}
+	}
+	return nil
+}
+
+func (m *MetricsFilter) Destroy() {}
+
+func (m *MetricsFilter) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	var cr *countReader
+	req.Wrap(func(r *http.Request, needClose bool) (*http.Request, bool) {
+		if r.Body != nil {
+			cr = &countReader{ReadCloser: r.Body}
+			r.Body = cr
+		}
+		return r, needClose
+	})
+
+	cw := &countWriter{}
+	resp.Wrap(func(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
+		cw.ResponseWriter = w
+		cw.needClose = needClose
+		return cw, true
+	})
+
+	chain(req, resp)
+
+	route := req.Vars().Pattern()
+	m.Metrics.ObserveRequest(route, req.ReqMethod(), resp.StatusCode(0), time.Since(req.StartedAt()))
+	if cr != nil {
+		m.Metrics.ObserveRequestSize(route, cr.n)
+	}
+	m.Metrics.ObserveResponseSize(route, cw.n)
+}
+
+func (r *countReader) Read(data []byte) (int, error) {
+	n, err := r.ReadCloser.Read(data)
+	r.n += n
+	return n, err
+}
+
+func (w *countWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.n += n
+	return n, err
+}
+
+func (w *countWriter) Flush() {
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+func (w *countWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, is := w.ResponseWriter.(http.Hijacker)
+	if !is {
+		return nil, nil, zerver.ErrHijack
+	}
+
+	return hijacker.Hijack()
+}
+
+func (w *countWriter) Close() error {
+	if w.needClose {
+		return w.ResponseWriter.(io.Closer).Close()
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package filter
+
+import (
+	"net/http"
+
+	"github.com/cosiner/zerver"
+)
+
+// OnError call Func after the chain completed if the final status is 4xx or
+// 5xx, such as reporting errors, it should be placed before the recovery
+// filter so panics replied with 500 are also reported
+type OnError struct {
+	Func func(req zerver.Request, resp zerver.Response, status int)
+}
+
+func OnErrorFilter(fn func(zerver.Request, zerver.Response, int)) zerver.Filter {
+	return &OnError{Func: fn}
+}
+
+func (o *OnError) Init(zerver.Env) error { return nil }
+
+func (o *OnError) Destroy() {}
+
+func (o *OnError) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	chain(req, resp)
+
+	if status := resp.Status(); status >= http.StatusBadRequest {
+		o.Func(req, resp, status)
+	}
+}
@@ -1,21 +1,49 @@
 package filter
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
 
-	"github.com/cosiner/gohper/utils/defval"
 	log "github.com/cosiner/ygo/jsonlog"
 	"github.com/cosiner/zerver"
 )
 
-type Recovery struct {
-	Bufsize int
-	log     *log.Logger
+type (
+	RecoveryOptions struct {
+		// max frames captured, default 32
+		StackSize int
+		// frames skipped from panic point, such as panic helpers
+		SkipFrames int
+	}
+
+	Recovery struct {
+		// Deprecated: if setted and StackSize is 0, raw stack of Bufsize bytes
+		// is logged as before.
+		Bufsize int
+		RecoveryOptions
+
+		server *zerver.Server
+		log    *log.Logger
+	}
+)
+
+// frames of these packages is dropped from stack
+var recoveryTrimPrefixes = []string{
+	"runtime.",
+	"net/http.",
+	"github.com/cosiner/zerver.",
+	"github.com/cosiner/zerver/filter.(*Recovery)",
 }
 
 func (r *Recovery) Init(env zerver.Env) error {
-	defval.Int(&r.Bufsize, 1024*4)
+	if r.StackSize == 0 && r.Bufsize == 0 {
+		r.StackSize = 32
+	}
+	r.server = env.Server()
 	r.log = log.Derive("Filter", "Recovery")
 	return nil
 }
@@ -25,15 +53,58 @@ func (r *Recovery) Destroy() {}
 func (r *Recovery) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
 	defer func() {
 		if err := recover(); err != nil {
-			resp.StatusCode(http.StatusInternalServerError)
-			buf := make([]byte, r.Bufsize)
-			n := runtime.Stack(buf, false)
-			buf = buf[:n]
-
-			r.log.Raw(0, log.LEVEL_ERROR, string(buf))
+			if r.server != nil {
+				r.server.ReportPanic(err)
+			}
+			if !resp.Written() {
+				resp.StatusCode(http.StatusInternalServerError)
+			}
+			r.log.Raw(0, log.LEVEL_ERROR, r.stack(err))
 			return
 		}
 	}()
 
 	chain(req, resp)
 }
+
+func (r *Recovery) stack(err interface{}) string {
+	if r.StackSize <= 0 {
+		buf := make([]byte, r.Bufsize)
+		n := runtime.Stack(buf, false)
+		return string(buf[:n])
+	}
+
+	// skip runtime.Callers, stack and the deferred function, trimmed frames
+	// also take slots
+	pcs := make([]uintptr, r.StackSize+r.SkipFrames+32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	buf.WriteString("panic: " + fmt.Sprint(err) + "\n")
+	var skipped, captured int
+	for captured < r.StackSize {
+		frame, more := frames.Next()
+		if !trimmedFrame(frame.Function) {
+			if skipped < r.SkipFrames {
+				skipped++
+			} else {
+				captured++
+				buf.WriteString(frame.Function + "\n\t" + frame.File + ":" + strconv.Itoa(frame.Line) + "\n")
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+func trimmedFrame(fn string) bool {
+	for _, prefix := range recoveryTrimPrefixes {
+		if strings.HasPrefix(fn, prefix) {
+			return true
+		}
+	}
+	return false
+}
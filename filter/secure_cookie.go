@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cosiner/zerver"
+)
+
+type (
+	// SecureCookie add missing attributes to all Set-Cookie headers of response
+	// when it's written, attributes already present are kept as is.
+	SecureCookie struct {
+		Secure   bool
+		HttpOnly bool
+		// "Strict", "Lax" or "None", empty means don't add
+		SameSite string
+	}
+
+	cookieWriter struct {
+		http.ResponseWriter
+		filter    *SecureCookie
+		rewrited  bool
+		needClose bool
+	}
+)
+
+func (c *SecureCookie) Init(zerver.Env) error { return nil }
+
+func (c *SecureCookie) Destroy() {}
+
+func (c *SecureCookie) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	resp.Wrap(func(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
+		return &cookieWriter{
+			ResponseWriter: w,
+			filter:         c,
+			needClose:      needClose,
+		}, true
+	})
+	chain(req, resp)
+}
+
+func (c *SecureCookie) harden(cookie string) string {
+	var secure, httpOnly, sameSite bool
+	for _, attr := range strings.Split(cookie, ";")[1:] {
+		attr = strings.ToLower(strings.TrimSpace(attr))
+		switch {
+		case attr == "secure":
+			secure = true
+		case attr == "httponly":
+			httpOnly = true
+		case strings.HasPrefix(attr, "samesite"):
+			sameSite = true
+		}
+	}
+
+	if c.Secure && !secure {
+		cookie += "; Secure"
+	}
+	if c.HttpOnly && !httpOnly {
+		cookie += "; HttpOnly"
+	}
+	if c.SameSite != "" && !sameSite {
+		cookie += "; SameSite=" + c.SameSite
+	}
+	return cookie
+}
+
+func (w *cookieWriter) rewrite() {
+	if w.rewrited {
+		return
+	}
+	w.rewrited = true
+
+	cookies := w.Header()[zerver.HEADER_SETCOOKIE]
+	for i := range cookies {
+		cookies[i] = w.filter.harden(cookies[i])
+	}
+}
+
+func (w *cookieWriter) WriteHeader(status int) {
+	w.rewrite()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cookieWriter) Write(data []byte) (int, error) {
+	w.rewrite()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cookieWriter) Flush() {
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+func (w *cookieWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, is := w.ResponseWriter.(http.Hijacker)
+	if !is {
+		return nil, nil, zerver.ErrHijack
+	}
+
+	return hijacker.Hijack()
+}
+
+func (w *cookieWriter) Close() error {
+	if w.needClose {
+		return w.ResponseWriter.(io.Closer).Close()
+	}
+	return nil
+}
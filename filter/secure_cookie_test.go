@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestSecureCookie(t *testing.T) {
+	tests := []struct {
+		filter  SecureCookie
+		cookies []string
+		expect  []string
+	}{
+		{
+			SecureCookie{Secure: true, HttpOnly: true, SameSite: "Lax"},
+			[]string{"a=1", "b=2; Path=/"},
+			[]string{"a=1; Secure; HttpOnly; SameSite=Lax", "b=2; Path=/; Secure; HttpOnly; SameSite=Lax"},
+		},
+		{
+			SecureCookie{Secure: true, HttpOnly: true, SameSite: "Lax"},
+			[]string{"a=1; secure; HTTPOnly; SameSite=Strict"},
+			[]string{"a=1; secure; HTTPOnly; SameSite=Strict"},
+		},
+		{
+			SecureCookie{Secure: true},
+			[]string{"a=1; HttpOnly"},
+			[]string{"a=1; HttpOnly; Secure"},
+		},
+		{
+			SecureCookie{},
+			[]string{"a=1"},
+			[]string{"a=1"},
+		},
+	}
+
+	for i, tt := range tests {
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				for _, c := range tt.cookies {
+					resp.Headers().Add(zerver.HEADER_SETCOOKIE, c)
+				}
+				resp.Write([]byte("a"))
+				resp.Write([]byte("b")) // attributes are added only once
+			}
+		})
+		f := tt.filter
+		h := zerver.HandlerToHTTP(handler, &f)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if got := w.Header()[zerver.HEADER_SETCOOKIE]; !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%d: want %q, got %q", i, tt.expect, got)
+		}
+	}
+}
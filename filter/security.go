@@ -0,0 +1,83 @@
+package filter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/cosiner/zerver"
+)
+
+const (
+	_HEADER_HSTS           = "Strict-Transport-Security"
+	_HEADER_CONTENTOPTIONS = "X-Content-Type-Options"
+	_HEADER_FRAMEOPTIONS   = "X-Frame-Options"
+	_HEADER_REFERRERPOLICY = "Referrer-Policy"
+	_HEADER_CSP            = "Content-Security-Policy"
+)
+
+type (
+	// SecurityConfig config headers setted by security headers filter, each header
+	// is disabled if it's zero value
+	SecurityConfig struct {
+		// max-age of Strict-Transport-Security, it's only emitted over tls connection
+		HSTSMaxAge            time.Duration
+		HSTSIncludeSubdomains bool
+		HSTSPreload           bool
+
+		// X-Content-Type-Options: nosniff
+		NoSniff bool
+		// X-Frame-Options, such as DENY, SAMEORIGIN
+		FrameOptions string
+		// Referrer-Policy, such as no-referrer, same-origin
+		ReferrerPolicy string
+		// Content-Security-Policy
+		ContentSecurityPolicy string
+	}
+
+	securityHeaders struct {
+		cfg  SecurityConfig
+		hsts string
+	}
+)
+
+// SecurityHeadersFilter set best-practice security headers for browser-facing
+// applications
+func SecurityHeadersFilter(cfg SecurityConfig) zerver.Filter {
+	return &securityHeaders{cfg: cfg}
+}
+
+func (s *securityHeaders) Init(zerver.Env) error {
+	if s.cfg.HSTSMaxAge > 0 {
+		s.hsts = "max-age=" + strconv.FormatInt(int64(s.cfg.HSTSMaxAge/time.Second), 10)
+		if s.cfg.HSTSIncludeSubdomains {
+			s.hsts += "; includeSubDomains"
+		}
+		if s.cfg.HSTSPreload {
+			s.hsts += "; preload"
+		}
+	}
+	return nil
+}
+
+func (s *securityHeaders) Destroy() {}
+
+func (s *securityHeaders) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	headers := resp.Headers()
+	if s.hsts != "" && req.IsTLS() {
+		headers.Set(_HEADER_HSTS, s.hsts)
+	}
+	if s.cfg.NoSniff {
+		headers.Set(_HEADER_CONTENTOPTIONS, "nosniff")
+	}
+	if s.cfg.FrameOptions != "" {
+		headers.Set(_HEADER_FRAMEOPTIONS, s.cfg.FrameOptions)
+	}
+	if s.cfg.ReferrerPolicy != "" {
+		headers.Set(_HEADER_REFERRERPOLICY, s.cfg.ReferrerPolicy)
+	}
+	if s.cfg.ContentSecurityPolicy != "" {
+		headers.Set(_HEADER_CSP, s.cfg.ContentSecurityPolicy)
+	}
+
+	chain(req, resp)
+}
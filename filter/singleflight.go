@@ -0,0 +1,205 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/cosiner/zerver"
+)
+
+const (
+	_HEADER_COOKIE = "Cookie"
+
+	_DEF_FLIGHT_MAX_BODY = 1 << 20 // 1M
+)
+
+type (
+	// SingleFlight let only one of concurrent GET/HEAD requests with same key
+	// go through the chain, others wait for it to complete and replay it's
+	// captured response, except Set-Cookie.
+	SingleFlight struct {
+		// key of request, empty key means don't merge. Requests with same key
+		// get same response, so it must contain everything the response depends
+		// on, such as user identity. Default use method and request uri, and
+		// requests carrying Authorization or Cookie are not merged.
+		Key func(zerver.Request) string
+		// max bytes of captured response body, if exceeded, waiting requests go
+		// through the chain by themselves, default 1M
+		MaxBody int
+
+		mu    sync.Mutex
+		calls map[string]*flightCall // key:in-flight call
+	}
+
+	flightCall struct {
+		wg   sync.WaitGroup
+		resp *flightResponse // nil if response can't be shared
+	}
+
+	flightResponse struct {
+		status int
+		header http.Header
+		body   []byte
+	}
+
+	flightWriter struct {
+		http.ResponseWriter
+		resp      *flightResponse
+		buffer    bytes.Buffer
+		max       int
+		overflow  bool
+		needClose bool
+	}
+)
+
+func SingleFlightFilter(keyFn func(zerver.Request) string) zerver.Filter {
+	return &SingleFlight{
+		Key: keyFn,
+	}
+}
+
+// defaultFlightKey don't merge requests with credentials, their responses may
+// be personalised
+func defaultFlightKey(req zerver.Request) string {
+	if req.GetHeader(zerver.HEADER_AUTHRIZATION) != "" || req.GetHeader(_HEADER_COOKIE) != "" {
+		return ""
+	}
+	return req.ReqMethod() + " " + req.RequestURI()
+}
+
+func (s *SingleFlight) Init(zerver.Env) error {
+	if s.Key == nil {
+		s.Key = defaultFlightKey
+	}
+	if s.MaxBody <= 0 {
+		s.MaxBody = _DEF_FLIGHT_MAX_BODY
+	}
+	s.calls = make(map[string]*flightCall)
+	return nil
+}
+
+func (s *SingleFlight) Destroy() {}
+
+func (s *SingleFlight) Filter(req zerver.Request, resp zerver.Response, chain zerver.FilterChain) {
+	method := req.ReqMethod()
+	if method != zerver.METHOD_GET && method != zerver.METHOD_HEAD {
+		chain(req, resp)
+		return
+	}
+	key := s.Key(req)
+	if key == "" {
+		chain(req, resp)
+		return
+	}
+
+	s.mu.Lock()
+	if c, has := s.calls[key]; has {
+		s.mu.Unlock()
+		c.wg.Wait()
+		if c.resp == nil {
+			chain(req, resp)
+		} else {
+			c.resp.replay(resp)
+		}
+		return
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	s.calls[key] = c
+	s.mu.Unlock()
+
+	defer func() { // waiting requests must be released even if chain panic
+		s.mu.Lock()
+		delete(s.calls, key)
+		s.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	fw := &flightWriter{resp: &flightResponse{status: http.StatusOK}, max: s.MaxBody}
+	resp.Wrap(func(w http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
+		fw.ResponseWriter = w
+		fw.needClose = needClose
+		return fw, true
+	})
+	chain(req, resp)
+	// commit status and buffered data so they are captured
+	resp.Write(nil)
+	resp.Flush()
+
+	if !fw.overflow {
+		fw.resp.body = fw.buffer.Bytes()
+		if fw.resp.header == nil {
+			fw.resp.header = cloneHeader(fw.Header())
+		}
+		fw.resp.header.Del(zerver.HEADER_SETCOOKIE)
+		c.resp = fw.resp
+	}
+}
+
+func (fr *flightResponse) replay(resp zerver.Response) {
+	headers := resp.Headers()
+	for k, vals := range fr.header {
+		headers[k] = append([]string(nil), vals...)
+	}
+	resp.StatusCode(fr.status)
+	if len(fr.body) != 0 {
+		resp.Write(fr.body)
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, vals := range h {
+		c[k] = append([]string(nil), vals...)
+	}
+	return c
+}
+
+func (w *flightWriter) WriteHeader(status int) {
+	if w.resp.header == nil {
+		w.resp.status = status
+		w.resp.header = cloneHeader(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *flightWriter) Write(data []byte) (int, error) {
+	if w.resp.header == nil {
+		w.resp.header = cloneHeader(w.Header())
+	}
+	if !w.overflow {
+		if w.buffer.Len()+len(data) > w.max {
+			w.overflow = true
+			w.buffer = bytes.Buffer{}
+		} else {
+			w.buffer.Write(data)
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *flightWriter) Flush() {
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+func (w *flightWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, is := w.ResponseWriter.(http.Hijacker)
+	if !is {
+		return nil, nil, zerver.ErrHijack
+	}
+
+	return hijacker.Hijack()
+}
+
+func (w *flightWriter) Close() error {
+	if w.needClose {
+		return w.ResponseWriter.(io.Closer).Close()
+	}
+	return nil
+}
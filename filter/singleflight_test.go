@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestSingleFlight(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		calls  int32
+	}{
+		{"anonymous", nil, 1},
+		{"cookie", http.Header{"Cookie": {"session=1"}}, 3},
+		{"authorization", http.Header{"Authorization": {"Bearer x"}}, 3},
+	}
+
+	for _, tt := range tests {
+		var (
+			calls   int32
+			entered = make(chan struct{}, 3)
+			release = make(chan struct{})
+		)
+		handler := zerver.HandlerFunc(func(string) zerver.HandleFunc {
+			return func(req zerver.Request, resp zerver.Response) {
+				atomic.AddInt32(&calls, 1)
+				entered <- struct{}{}
+				<-release
+				resp.Headers().Set(zerver.HEADER_SETCOOKIE, "user=leader")
+				resp.Write([]byte("body"))
+			}
+		})
+		f := &SingleFlight{}
+		f.Init(nil)
+		h := zerver.HandlerToHTTP(handler, f)
+
+		var (
+			wg      sync.WaitGroup
+			results = make([]*httptest.ResponseRecorder, 3)
+		)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", "/res", nil)
+				for k, v := range tt.header {
+					req.Header[k] = v
+				}
+				results[i] = httptest.NewRecorder()
+				h.ServeHTTP(results[i], req)
+			}(i)
+			if i == 0 {
+				<-entered // the first one is leader
+			}
+		}
+		time.Sleep(50 * time.Millisecond) // let others wait or enter
+		close(release)
+		wg.Wait()
+
+		if calls != tt.calls {
+			t.Errorf("%s: handler called %d times, expect %d", tt.name, calls, tt.calls)
+		}
+		var leaderCookies int
+		for _, w := range results {
+			if w.Body.String() != "body" {
+				t.Errorf("%s: body %q, expect \"body\"", tt.name, w.Body.String())
+			}
+			if w.Header().Get(zerver.HEADER_SETCOOKIE) != "" {
+				leaderCookies++
+			}
+		}
+		if leaderCookies != int(tt.calls) {
+			t.Errorf("%s: %d responses has Set-Cookie, expect %d", tt.name, leaderCookies, tt.calls)
+		}
+	}
+}
+
+func TestSingleFlightMaxBody(t *testing.T) {
+	w := &flightWriter{
+		ResponseWriter: httptest.NewRecorder(),
+		resp:           &flightResponse{},
+		max:            4,
+	}
+	w.Write([]byte("abc"))
+	if w.overflow {
+		t.Fatal("overflow before max")
+	}
+	w.Write([]byte("de"))
+	if !w.overflow || w.buffer.Len() != 0 {
+		t.Fatal("captured body exceed max")
+	}
+}
@@ -0,0 +1,40 @@
+package zerver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFilterChainAbort(t *testing.T) {
+	var invoked bool
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Filter("/", FilterFunc(func(req Request, resp Response, chain FilterChain) {
+			if req.GetHeader("Authorization") == "" {
+				chain.Abort(resp, http.StatusUnauthorized)
+				return
+			}
+			chain.Next(req, resp)
+		}))
+		rt.Filter("/", FilterFunc(func(req Request, resp Response, chain FilterChain) {
+			chain(req, resp)
+			invoked = req.HandlerInvoked()
+		}))
+		rt.Handle("/", []string{METHOD_GET}, func(req Request, resp Response) {})
+	})
+
+	tests := []struct {
+		auth    string
+		code    int
+		invoked bool
+	}{
+		{"", http.StatusUnauthorized, false},
+		{"token", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		invoked = false
+		w := serve(s, METHOD_GET, "/", nil, http.Header{"Authorization": {tt.auth}})
+		if w.Code != tt.code || invoked != tt.invoked {
+			t.Errorf("%q: want %d %t, got %d %t", tt.auth, tt.code, tt.invoked, w.Code, invoked)
+		}
+	}
+}
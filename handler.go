@@ -1,5 +1,7 @@
 package zerver
 
+import "context"
+
 type (
 	HandleFunc func(Request, Response)
 
@@ -10,6 +12,27 @@ type (
 
 	HandlerFunc func(method string) HandleFunc
 
+	// Drainable handler is notified to wind down long-lived streams such as
+	// long polls and SSE when server is destroying, it's called before
+	// listeners closed, ctx is the destroy context
+	Drainable interface {
+		Drain(ctx context.Context)
+	}
+
+	// RawOutput mark a handler write raw output such as redirects and files,
+	// the default Content-Type of ServerOption.Headers is removed before
+	// filters, see Response.DisableContentType
+	RawOutput interface {
+		RawOutput()
+	}
+
+	// methodsHandler serve the same handle func for some methods, empty methods
+	// means any method
+	methodsHandler struct {
+		methods []string
+		fn      HandleFunc
+	}
+
 	interceptor struct {
 		filter  Filter
 		handler HandleFunc
@@ -26,6 +49,39 @@ func (h HandlerFunc) Handler(method string) HandleFunc {
 	return h(method)
 }
 
+func isRawOutput(h Handler) bool {
+	if oh, is := h.(*optionHandler); is {
+		h = oh.handler
+	}
+	_, is := h.(RawOutput)
+	return is
+}
+
+func newMethodsHandler(methods []string, fn HandleFunc) Handler {
+	h := &methodsHandler{fn: fn}
+	for _, m := range methods {
+		h.methods = append(h.methods, MethodName(m))
+	}
+	return h
+}
+
+func (*methodsHandler) Init(Env) error { return nil }
+
+func (*methodsHandler) Destroy() {}
+
+func (h *methodsHandler) Handler(method string) HandleFunc {
+	if len(h.methods) == 0 {
+		return h.fn
+	}
+
+	for _, m := range h.methods {
+		if m == method {
+			return h.fn
+		}
+	}
+	return nil
+}
+
 // Intercept will create an immutable filter chain
 // interceptors' lifetime should be managed by the Server
 func Intercept(handler HandleFunc, interceptors ...Filter) HandleFunc {
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/cosiner/gohper/errors"
+	log "github.com/cosiner/ygo/jsonlog"
+	"github.com/cosiner/zerver"
+)
+
+const (
+	ErrRPCMethodExists  = errors.Err("rpc method already exists")
+	ErrInvalidRPCMethod = errors.Err("rpc method must be func([zerver.Request], [params]) ([result], error)")
+)
+
+// standard JSON-RPC 2.0 error codes
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+)
+
+type (
+	// RPCError is the error object of JSON-RPC 2.0, methods can return it to
+	// custom code and data
+	RPCError struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"`
+	}
+
+	// JSONRPCHandler serve JSON-RPC 2.0 over POST, both single and batch
+	// requests is supported, responses of batch keep the order of requests,
+	// notifications get no response. If there is nothing to respond, 204 is
+	// replied.
+	JSONRPCHandler struct {
+		methods map[string]*rpcMethod
+		log     *log.Logger
+	}
+
+	rpcMethod struct {
+		fn         reflect.Value
+		withReq    bool
+		params     reflect.Type // nil if no params
+		withResult bool
+	}
+
+	rpcRequest struct {
+		Version string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"` // empty for notification, "null" is a valid id
+	}
+
+	rpcResponse struct {
+		Version string          `json:"jsonrpc"`
+		Result  json.RawMessage `json:"result,omitempty"` // "null" if method has no result
+		Error   *RPCError       `json:"error,omitempty"`
+		ID      json.RawMessage `json:"id"`
+	}
+)
+
+var (
+	requestType = reflect.TypeOf((*zerver.Request)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	nullID      = json.RawMessage("null")
+)
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func NewJSONRPCHandler() *JSONRPCHandler {
+	return &JSONRPCHandler{
+		methods: make(map[string]*rpcMethod),
+	}
+}
+
+// RegisterRPC register a method, fn must be a function like
+// func([zerver.Request], [params]) ([result], error), params is decoded from
+// "params" of request by encoding/json, it can be struct, map or slice for
+// named or positional parameters. Methods must be registered before serving.
+func (h *JSONRPCHandler) RegisterRPC(name string, fn interface{}) error {
+	if _, has := h.methods[name]; has {
+		return ErrRPCMethodExists
+	}
+
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.IsVariadic() {
+		return ErrInvalidRPCMethod
+	}
+
+	m := &rpcMethod{fn: fv}
+	in := 0
+	if in < ft.NumIn() && ft.In(in) == requestType {
+		m.withReq = true
+		in++
+	}
+	if in < ft.NumIn() {
+		m.params = ft.In(in)
+		in++
+	}
+	if in != ft.NumIn() {
+		return ErrInvalidRPCMethod
+	}
+
+	switch ft.NumOut() {
+	case 2:
+		m.withResult = true
+		fallthrough
+	case 1:
+		if ft.Out(ft.NumOut()-1) != errorType {
+			return ErrInvalidRPCMethod
+		}
+	default:
+		return ErrInvalidRPCMethod
+	}
+
+	h.methods[name] = m
+	return nil
+}
+
+func (h *JSONRPCHandler) Init(zerver.Env) error {
+	h.log = log.Derive("Handler", "JSONRPC")
+	return nil
+}
+
+func (h *JSONRPCHandler) Destroy() {}
+
+func (h *JSONRPCHandler) Handler(method string) zerver.HandleFunc {
+	if method == zerver.METHOD_POST {
+		return h.serve
+	}
+	return nil
+}
+
+func (h *JSONRPCHandler) serve(req zerver.Request, resp zerver.Response) {
+	body, err := ioutil.ReadAll(req)
+	if err != nil {
+		resp.StatusCode(http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err = json.Unmarshal(body, &batch); err != nil {
+			result = rpcErrorResponse(nullID, RPC_PARSE_ERROR, "parse error")
+		} else if len(batch) == 0 {
+			result = rpcErrorResponse(nullID, RPC_INVALID_REQUEST, "empty batch")
+		} else {
+			resps := make([]*rpcResponse, 0, len(batch))
+			for _, raw := range batch {
+				if r := h.call(req, raw); r != nil {
+					resps = append(resps, r)
+				}
+			}
+			if len(resps) != 0 {
+				result = resps
+			}
+		}
+	} else if r := h.call(req, body); r != nil {
+		result = r
+	}
+
+	if result == nil { // notifications only
+		resp.StatusCode(http.StatusNoContent)
+		return
+	}
+
+	resp.Headers().Set(zerver.HEADER_CONTENTTYPE, "application/json; charset=utf-8")
+	if err = json.NewEncoder(resp).Encode(result); err != nil {
+		h.log.Warn(log.M{"msg": "write rpc response failed", "err": err.Error()})
+	}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, msg string) *rpcResponse {
+	return &rpcResponse{
+		Version: "2.0",
+		Error:   &RPCError{Code: code, Message: msg},
+		ID:      id,
+	}
+}
+
+// call invoke the method of a request object, nil is returned for notification
+func (h *JSONRPCHandler) call(req zerver.Request, raw []byte) (resp *rpcResponse) {
+	var r rpcRequest
+	if err := json.Unmarshal(raw, &r); err != nil {
+		if _, is := err.(*json.SyntaxError); is {
+			return rpcErrorResponse(nullID, RPC_PARSE_ERROR, "parse error")
+		}
+		return rpcErrorResponse(nullID, RPC_INVALID_REQUEST, "invalid request")
+	}
+
+	id := r.ID
+	notification := len(id) == 0
+	if notification {
+		id = nullID
+	}
+	if r.Version != "2.0" || r.Method == "" {
+		return rpcErrorResponse(id, RPC_INVALID_REQUEST, "invalid request")
+	}
+
+	m := h.methods[r.Method]
+	if m == nil {
+		if notification {
+			return nil
+		}
+		return rpcErrorResponse(id, RPC_METHOD_NOT_FOUND, "method not found")
+	}
+
+	args := make([]reflect.Value, 0, 2)
+	if m.withReq {
+		args = append(args, reflect.ValueOf(req))
+	}
+	if m.params != nil {
+		pv := reflect.New(m.params)
+		if len(r.Params) != 0 {
+			if err := json.Unmarshal(r.Params, pv.Interface()); err != nil {
+				if notification {
+					return nil
+				}
+				return rpcErrorResponse(id, RPC_INVALID_PARAMS, err.Error())
+			}
+		}
+		args = append(args, pv.Elem())
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			h.log.Error(log.M{"msg": "rpc method panic", "method": r.Method, "err": fmt.Sprint(e)})
+			resp = nil
+			if !notification {
+				resp = rpcErrorResponse(id, RPC_INTERNAL_ERROR, "internal error")
+			}
+		}
+	}()
+
+	outs := m.fn.Call(args)
+	if notification {
+		return nil
+	}
+
+	resp = &rpcResponse{Version: "2.0", ID: id}
+	if err, _ := outs[len(outs)-1].Interface().(error); err != nil {
+		if rerr, is := err.(*RPCError); is {
+			resp.Error = rerr
+		} else {
+			resp.Error = &RPCError{Code: RPC_INTERNAL_ERROR, Message: err.Error()}
+		}
+		return resp
+	}
+	resp.Result = nullID
+	if m.withResult {
+		result, err := json.Marshal(outs[0].Interface())
+		if err != nil {
+			return rpcErrorResponse(id, RPC_INTERNAL_ERROR, err.Error())
+		}
+		resp.Result = result
+	}
+	return resp
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+func newTestRPC(t *testing.T) http.Handler {
+	h := NewJSONRPCHandler()
+	methods := map[string]interface{}{
+		"add": func(args []int) (int, error) {
+			return args[0] + args[1], nil
+		},
+		"hello": func(req zerver.Request, p struct{ Name string }) (string, error) {
+			return "hello " + p.Name, nil
+		},
+		"fail": func() error {
+			return &RPCError{Code: 1, Message: "failed"}
+		},
+		"panic": func() error {
+			panic("boom")
+		},
+	}
+	for name, fn := range methods {
+		if err := h.RegisterRPC(name, fn); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	h.Init(nil)
+	return zerver.HandlerToHTTP(h)
+}
+
+func TestRegisterRPC(t *testing.T) {
+	h := NewJSONRPCHandler()
+	tests := []struct {
+		fn  interface{}
+		err error
+	}{
+		{func() error { return nil }, nil},
+		{func(zerver.Request) (int, error) { return 0, nil }, nil},
+		{func(int, int) error { return nil }, ErrInvalidRPCMethod},
+		{func() int { return 0 }, ErrInvalidRPCMethod},
+		{func() (int, int, error) { return 0, 0, nil }, ErrInvalidRPCMethod},
+		{func(...int) error { return nil }, ErrInvalidRPCMethod},
+		{1, ErrInvalidRPCMethod},
+	}
+	for i, tt := range tests {
+		if err := h.RegisterRPC(string(rune('a'+i)), tt.fn); err != tt.err {
+			t.Errorf("%d: err %v, expect %v", i, err, tt.err)
+		}
+	}
+	if err := h.RegisterRPC("a", func() error { return nil }); err != ErrRPCMethodExists {
+		t.Errorf("duplicate method: err %v", err)
+	}
+}
+
+func TestJSONRPC(t *testing.T) {
+	h := newTestRPC(t)
+	tests := []struct {
+		body   string
+		status int
+		resp   string
+	}{
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, 200,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"hello","params":{"name":"rpc"},"id":"a"}`, 200,
+			`{"jsonrpc":"2.0","result":"hello rpc","id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"fail","id":null}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":1,"message":"failed"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":"panic","id":2}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal error"},"id":2}`},
+		{`{"jsonrpc":"2.0","method":"none","id":3}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":3}`},
+		{`{"jsonrpc":"1.0","method":"add","id":5}`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":5}`},
+		{`{"jsonrpc":`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`},
+		{`[]`, 200,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2]}`, 204, ``},
+		{`[{"jsonrpc":"2.0","method":"add","params":[1,2]},{"jsonrpc":"2.0","method":"none"}]`, 204, ``},
+		{`[{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},{"jsonrpc":"2.0","method":"add","params":[3,4]},1]`, 200,
+			`[{"jsonrpc":"2.0","result":3,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}]`},
+	}
+
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/rpc", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%d: status %d, expect %d", i, w.Code, tt.status)
+			continue
+		}
+		if tt.resp == "" {
+			continue
+		}
+		var got, expect bytes.Buffer
+		json.Compact(&got, w.Body.Bytes())
+		json.Compact(&expect, []byte(tt.resp))
+		if got.String() != expect.String() {
+			t.Errorf("%d: response %s, expect %s", i, got.String(), expect.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"add","params":"x","id":4}`)))
+	var resp rpcResponse
+	if json.Unmarshal(w.Body.Bytes(), &resp); resp.Error == nil || resp.Error.Code != RPC_INVALID_PARAMS {
+		t.Errorf("invalid params: response %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/rpc", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, expect 405", w.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package zerver
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+
+	log "github.com/cosiner/ygo/jsonlog"
+)
+
+// the response written by net/http directly to connection when request line
+// and headers exceed MaxHeaderBytes, it's sent in a single write before close
+var (
+	_HEADER_TOO_LARGE_PREFIX = []byte("HTTP/1.1 431 ")
+	_HEADER_TOO_LARGE_SUFFIX = []byte("\r\n\r\n431 Request Header Fields Too Large")
+)
+
+type (
+	// headerLimitListener watch connections for the 431 reply of standard
+	// library, it only works for plaintext connections, for tls connections the
+	// reply is encrypted before it reach here
+	headerLimitListener struct {
+		net.Listener
+		s *Server
+	}
+
+	headerLimitConn struct {
+		net.Conn
+		s *Server
+	}
+)
+
+func (l headerLimitListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &headerLimitConn{Conn: c, s: l.s}, nil
+}
+
+func (c *headerLimitConn) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, _HEADER_TOO_LARGE_PREFIX) && bytes.HasSuffix(p, _HEADER_TOO_LARGE_SUFFIX) {
+		c.s.headerTooLarge(c.RemoteAddr().String(), "MaxHeaderBytes")
+	}
+	return c.Conn.Write(p)
+}
+
+// ReadFrom keep the sendfile optimization of underlying tcp connection
+func (c *headerLimitConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+// headerTooLarge record a request rejected for oversized headers
+func (s *Server) headerTooLarge(remote, limit string) {
+	atomic.AddInt64(&s.oversizedHeaders, 1)
+	s.log.Warn(log.M{"msg": "request headers too large", "remote": remote, "limit": limit})
+}
+
+// OversizedHeaders return count of requests rejected with 431 by
+// MaxHeaderBytes or MaxHeaderCount, rejections of MaxHeaderBytes are only
+// counted for plaintext listeners
+func (s *Server) OversizedHeaders() int64 {
+	return atomic.LoadInt64(&s.oversizedHeaders)
+}
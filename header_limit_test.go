@@ -0,0 +1,46 @@
+package zerver
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHeaderLimitListener pin the behaviour of net/http that the 431 reply for
+// oversized headers is written in a single Write, it's detected by
+// headerLimitConn
+func TestHeaderLimitListener(t *testing.T) {
+	s := newTestServer(t, nil, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: s, MaxHeaderBytes: 1 << 10}
+	go srv.Serve(headerLimitListener{Listener: ln, s: s})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nX-Large: " + strings.Repeat("a", 8<<10) + "\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("want 431, got %d", resp.StatusCode)
+	}
+	if n := s.OversizedHeaders(); n != 1 {
+		t.Fatalf("oversized headers: want 1, got %d", n)
+	}
+}
@@ -0,0 +1,76 @@
+package zerver
+
+import (
+	"net"
+)
+
+// _DEFAULT_HOST is the host of filters for requests without their own host filters
+const _DEFAULT_HOST = "*"
+
+// HostFilter register a filter for requests of host, such as authorization for
+// "api.example.com" and caching for "static.example.com". These filters run
+// before filters of routes in registration order, both http and websocket
+// requests are filtered. If request host has port and there is no filters for
+// it, the port is ignored, host "*" is the default for hosts without filters.
+//
+// Filters must be registered before server start, they are initialized after
+// Router and destroyed with it.
+func (s *Server) HostFilter(host string, f Filter) {
+	if f == nil {
+		panic("nil host filter is not allowed")
+	}
+	if s.hostFilters == nil {
+		s.hostFilters = make(map[string][]Filter)
+	}
+	s.hostFilters[host] = append(s.hostFilters[host], f)
+}
+
+func (s *Server) matchHostFilters(host string) []Filter {
+	if len(s.hostFilters) == 0 {
+		return nil
+	}
+
+	if fs, has := s.hostFilters[host]; has {
+		return fs
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		if fs, has := s.hostFilters[h]; has {
+			return fs
+		}
+	}
+	return s.hostFilters[_DEFAULT_HOST]
+}
+
+// withHostFilters prepend host filters to filters of route
+func (s *Server) withHostFilters(host string, filters []Filter) []Filter {
+	hfs := s.matchHostFilters(host)
+	if len(hfs) == 0 {
+		return filters
+	}
+	if len(filters) == 0 {
+		return hfs
+	}
+
+	all := make([]Filter, 0, len(hfs)+len(filters))
+	all = append(all, hfs...)
+	return append(all, filters...)
+}
+
+func (s *Server) initHostFilters() error {
+	for host, fs := range s.hostFilters {
+		for _, f := range fs {
+			if err := initComponent(s, "host filter", host, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) destroyHostFilters() {
+	for _, fs := range s.hostFilters {
+		for _, f := range fs {
+			f.Destroy()
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package zerver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/cosiner/gohper/encoding"
+	log "github.com/cosiner/ygo/jsonlog"
+)
+
+type (
+	httpMiddleware struct {
+		mw func(http.Handler) http.Handler
+	}
+
+	// middlewareWriter is the writer passed to net/http middleware, it write to
+	// the original writer directly and keep response status in sync
+	middlewareWriter struct {
+		http.ResponseWriter
+		resp *response
+	}
+
+	// httpHandler serve a handler without a running server
+	httpHandler struct {
+		env     *Server
+		handler Handler
+		filters []Filter
+	}
+
+	// middlewareOutput replace response writer if middleware wrapped it, the
+	// original writer is still closed on response destroy
+	middlewareOutput struct {
+		http.ResponseWriter
+		orig      http.ResponseWriter
+		needClose bool
+	}
+)
+
+// WrapHTTPMiddleware bridge standard net/http middleware to Filter, the
+// continuation of filter chain is called as the next http.Handler.
+//
+// The *http.Request and http.ResponseWriter passed to middleware belong to
+// pooled Request/Response, they must not be retained after the middleware
+// return. If middleware replace the request or writer, the replacement is used
+// by following filters and handler. Filters wrap response writer after this one
+// will be closed after middleware returned, place them before it if middleware
+// need to see their output.
+func WrapHTTPMiddleware(mw func(http.Handler) http.Handler) Filter {
+	return httpMiddleware{mw: mw}
+}
+
+func (httpMiddleware) Init(Env) error { return nil }
+
+func (httpMiddleware) Destroy() {}
+
+func (m httpMiddleware) Filter(req Request, resp Response, chain FilterChain) {
+	r := resp.(*response)
+
+	var hreq *http.Request
+	req.Wrap(func(request *http.Request, needClose bool) (*http.Request, bool) {
+		hreq = request
+		return request, needClose
+	})
+	w := &middlewareWriter{ResponseWriter: r.ResponseWriter, resp: r}
+
+	m.mw(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		if request != hreq {
+			req.Wrap(func(_ *http.Request, needClose bool) (*http.Request, bool) {
+				return request, needClose
+			})
+		}
+		if rw != w {
+			resp.Wrap(func(orig http.ResponseWriter, needClose bool) (http.ResponseWriter, bool) {
+				return &middlewareOutput{ResponseWriter: rw, orig: orig, needClose: needClose}, true
+			})
+		}
+
+		chain(req, resp)
+		r.flushBuffer()
+		r.flushHeader() // middleware may need the status before return
+	})).ServeHTTP(w, hreq)
+}
+
+func (w *middlewareWriter) WriteHeader(status int) {
+	if !w.resp.statusWrited {
+		w.resp.status = status
+		w.resp.statusWrited = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *middlewareWriter) Write(data []byte) (int, error) {
+	if !w.resp.statusWrited {
+		w.resp.status = http.StatusOK // implicit status of net/http
+		w.resp.statusWrited = true
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *middlewareWriter) Flush() {
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+func (w *middlewareWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, is := w.ResponseWriter.(http.Hijacker)
+	if !is {
+		return nil, nil, ErrHijack
+	}
+
+	w.resp.hijacked = true
+	return hijacker.Hijack()
+}
+
+func (w *middlewareOutput) Flush() {
+	if flusher, is := w.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+func (w *middlewareOutput) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, is := w.ResponseWriter.(http.Hijacker)
+	if !is {
+		return nil, nil, ErrHijack
+	}
+
+	return hijacker.Hijack()
+}
+
+func (w *middlewareOutput) Close() error {
+	if w.needClose {
+		return w.orig.(io.Closer).Close()
+	}
+	return nil
+}
+
+// HTTPHandler return server as a http.Handler, it can be mounted to other
+// muxes, the server must be configured by Start or manually before serving.
+func (s *Server) HTTPHandler() http.Handler {
+	return s
+}
+
+// HandlerToHTTP convert a handler and filters to http.Handler without a full
+// server, the request environment use default json codec and a standalone
+// logger, components and tasks are unavailable.
+//
+// The handler and filters are not initialized or destroyed automatically,
+// their lifetime should be managed by caller.
+func HandlerToHTTP(h Handler, filters ...Filter) http.Handler {
+	env := NewServer(".")
+	env.codec = encoding.JSON
+	env.log = log.Derive("Framework", "HTTPHandler")
+
+	return &httpHandler{
+		env:     env,
+		handler: h,
+		filters: filters,
+	}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	var vars ReqVars
+	reqEnv := newRequestEnv()
+	req := reqEnv.req.init(h.env, request, &vars)
+	resp := reqEnv.resp.init(h.env, w)
+	if isRawOutput(h.handler) {
+		resp.DisableContentType()
+	}
+
+	chain := FilterChain(h.handler.Handler(req.ReqMethod()))
+	if chain == nil {
+		resp.StatusCode(http.StatusMethodNotAllowed)
+	}
+	serveChain(req, resp, chain, h.filters)
+
+	req.destroy()
+	resp.destroy()
+	recycleRequestEnv(reqEnv)
+}
@@ -3,10 +3,18 @@ package zerver
 import "net/url"
 
 type ReqVars struct {
+	pattern   string
 	urlVars   map[string]int
 	urlVals   []string
 	queryVars url.Values
 	formVars  url.Values
+	lazyForm  *request // form of request is parsed on first FormVar
+}
+
+// Pattern return the registered pattern of matched route, it's empty if
+// there is no route matched
+func (v *ReqVars) Pattern() string {
+	return v.pattern
 }
 
 // URLVar return values of variable
@@ -28,7 +36,14 @@ func (v *ReqVars) QueryVar(name string) string {
 	return v.queryVars.Get(name)
 }
 
+// FormVar return value of form body, for "Expect: 100-continue"
+// request, form is parsed on first call, then "100 Continue" is sent
 func (v *ReqVars) FormVar(name string) string {
+	if req := v.lazyForm; req != nil {
+		v.lazyForm = nil
+		req.Request.ParseForm()
+		v.queryVars, v.formVars = req.Request.Form, req.Request.PostForm
+	}
 	if v.formVars == nil {
 		return ""
 	}
@@ -1,13 +1,21 @@
 package zerver
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/cosiner/gohper/encoding"
 	"github.com/cosiner/gohper/errors"
+	"github.com/cosiner/gohper/time2"
 	"github.com/cosiner/gohper/utils/attrs"
 )
 
@@ -26,17 +34,77 @@ type (
 
 		ReqMethod() string
 		URL() *url.URL
+		// Protocol return protocol version such as "HTTP/1.1", "HTTP/2.0"
+		Protocol() string
+		// RequestURI return the unmodified request uri sent by client
+		RequestURI() string
+		// BodyNeeded report whether response body will be sent to client, it's
+		// false for HEAD requests, handlers can skip generating body but still
+		// set headers such as Content-Length
+		BodyNeeded() bool
 		GetHeader(name string) string
 		RemoteAddr() string
 		Authorization() (string, bool)
+		// TLS return tls connection state, it's nil if request isn't over tls
+		TLS() *tls.ConnectionState
+		// IsTLS report whether request is over tls
+		IsTLS() bool
+		// NegotiatedProtocol return the protocol negotiated by tls ALPN such as
+		// "h2", it's empty if request isn't over tls or there is no negotiation
+		NegotiatedProtocol() string
+		// ExpectContinue report whether client is waiting for "100 Continue"
+		// before sending body, see Response.WriteContinue
+		ExpectContinue() bool
+		// Context return the context of request, it's done when client
+		// disconnect or route deadline exceeded, see Timeout
+		Context() context.Context
+		// StartedAt return the time request received by server, latency should be
+		// computed from it
+		StartedAt() time.Time
 
 		Vars() *ReqVars
+		// PathVarCount return count of path variables declared by matched route
+		PathVarCount() int
+		// QueryArray return all values of query parameter, it's repeated keys
+		// like "?id=1&id=2", and also "name[]" if ServerOption.QueryArrayBrackets
+		// is enabled
+		QueryArray(name string) []string
 		attrs.Attrs
 		Env
 		io.Reader
 
+		// BytesRead return estimated bytes read from client, it's the size of
+		// request line, headers and body read so far. It's computed from parsed
+		// request, so the raw size may be a little different, and the body is
+		// counted before any decoding such as decompression.
+		BytesRead() int64
+
+		// HandlerInvoked report whether the filter chain reached the end, it's
+		// false if any filter aborted the request
+		HandlerInvoked() bool
+
+		// Receive decode request body by server codec
 		Receive(interface{}) error
+		// JSONUseNumber override ServerOption.JSONUseNumber for this request,
+		// it must be called before Receive
+		JSONUseNumber(use bool)
+		// JSONDecoder return a json decoder over body for streams such as
+		// newline-delimited json, the body may be wrapped by filters such
+		// as decompression, ServerOption.MaxBodyBytes is the cap of total bytes
+		JSONDecoder() *json.Decoder
+		// ReadBodyInto read whole body into buf without allocation, buffers
+		// can be pooled by handlers. If body is larger than buf,
+		// io.ErrShortBuffer is returned with buf filled, the remains can
+		// still be read from request, body beyond ServerOption.MaxBodyBytes
+		// is also an error.
+		ReadBodyInto(buf []byte) (int, error)
+		// BindQuery bind query parameters into struct pointed by v by field tag
+		// `query:"name"`, `default:"value"` is used if parameter is absent, slice
+		// fields collect values of QueryArray. If v implement Validator, it's
+		// called after binding.
+		BindQuery(v interface{}) error
 		destroy()
+		handlerInvoked()
 	}
 
 	// request represent an income request
@@ -47,6 +115,27 @@ type (
 
 		vars      *ReqVars
 		needClose bool
+		startedAt time.Time
+		invoked   bool
+		useNumber bool
+		codec     encoding.Codec // selected by Server.SetCodecSelector
+		body      *countBody     // nil if request has no body
+		probe     [1]byte        // read ahead by ReadBodyInto, avoid allocation
+	}
+
+	// probedBody is the request body with bytes read ahead put back
+	probedBody struct {
+		io.Reader
+		io.Closer
+	}
+
+	// countBody count bytes read from request body, it's allocated for each
+	// request instead of pooled with request, because the raw http.Request
+	// may be referenced after handler returned
+	countBody struct {
+		io.ReadCloser
+		n       int64
+		timeout bool // read timeout, client is too slow
 	}
 )
 
@@ -56,12 +145,25 @@ var (
 
 // newRequest create a new request
 func (req *request) init(e Env, requ *http.Request, reqVars *ReqVars) Request {
+	req.startedAt = time2.Now()
 	req.Env = e
 	req.Request = requ
+	req.useNumber = e.Server().jsonUseNumber
+	if requ.Body != nil {
+		req.body = &countBody{ReadCloser: requ.Body}
+		requ.Body = req.body
+	}
 
-	requ.ParseForm()
-	reqVars.queryVars = requ.Form
-	reqVars.formVars = requ.PostForm
+	if req.ExpectContinue() {
+		// parse form body will send "100 Continue", delay it until form is
+		// accessed, filters can still reject request before that
+		reqVars.queryVars = requ.URL.Query()
+		reqVars.lazyForm = req
+	} else {
+		requ.ParseForm()
+		reqVars.queryVars = requ.Form
+		reqVars.formVars = requ.PostForm
+	}
 	req.vars = reqVars
 
 	method := requ.Method
@@ -78,12 +180,15 @@ func (req *request) destroy() {
 	req.Attrs.Clear()
 	req.Env = nil
 	req.vars = nil
+	req.invoked = false
+	req.codec = nil
 
 	if req.needClose {
 		req.needClose = false
 		req.Body.Close()
 	}
 	req.Request = nil
+	req.body = nil
 }
 
 func (req *request) Wrap(fn RequestWrapper) {
@@ -108,10 +213,105 @@ func (req *request) RemoteAddr() string {
 	return req.Request.RemoteAddr
 }
 
+func (req *request) Protocol() string {
+	return req.Request.Proto
+}
+
+func (req *request) RequestURI() string {
+	return req.Request.RequestURI
+}
+
+func (b *countBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	b.n += int64(n)
+	if ne, is := err.(net.Error); is && ne.Timeout() {
+		b.timeout = true
+	}
+	return n, err
+}
+
+func (req *request) BytesRead() int64 {
+	r := req.Request
+	// request line, Host header and the blank line after headers
+	n := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4 + len("Host: ") + len(r.Host) + 2 + 2
+	size := int64(n + headerSize(r.Header))
+	if req.body != nil {
+		size += req.body.n
+	}
+	return size
+}
+
+// headerSize return bytes of "Key: Value\r\n" lines
+func headerSize(headers http.Header) int {
+	var n int
+	for k, vals := range headers {
+		for _, v := range vals {
+			n += len(k) + len(v) + 4
+		}
+	}
+	return n
+}
+
+func (req *request) HandlerInvoked() bool {
+	return req.invoked
+}
+
+func (req *request) handlerInvoked() {
+	req.invoked = true
+}
+
+func (req *request) BodyNeeded() bool {
+	return req.Method != METHOD_HEAD
+}
+
+func (req *request) StartedAt() time.Time {
+	return req.startedAt
+}
+
+func (req *request) TLS() *tls.ConnectionState {
+	return req.Request.TLS
+}
+
+func (req *request) IsTLS() bool {
+	return req.Request.TLS != nil
+}
+
+func (req *request) NegotiatedProtocol() string {
+	if req.Request.TLS == nil {
+		return ""
+	}
+	return req.Request.TLS.NegotiatedProtocol
+}
+
+func (req *request) ExpectContinue() bool {
+	return strings.EqualFold(req.Header.Get(HEADER_EXPECT), "100-continue")
+}
+
 func (req *request) Vars() *ReqVars {
 	return req.vars
 }
 
+func (req *request) PathVarCount() int {
+	return len(req.vars.urlVars)
+}
+
+func (req *request) QueryArray(name string) []string {
+	vals := req.vars.queryVars[name]
+	if !req.Server().queryArrayBrackets || strings.HasSuffix(name, "[]") {
+		return vals
+	}
+
+	bvals := req.vars.queryVars[name+"[]"]
+	if len(vals) == 0 {
+		return bvals
+	}
+	if len(bvals) == 0 {
+		return vals
+	}
+	all := make([]string, 0, len(vals)+len(bvals))
+	return append(append(all, vals...), bvals...)
+}
+
 func (req *request) Authorization() (string, bool) {
 	basic, auth := false, req.GetHeader(HEADER_AUTHRIZATION)
 	if basic = strings.HasPrefix(auth, "Basic "); basic {
@@ -130,6 +330,59 @@ func (req *request) Read(data []byte) (int, error) {
 	return req.Body.Read(data)
 }
 
+func (req *request) Codec() encoding.Codec {
+	if req.codec != nil {
+		return req.codec
+	}
+	return req.Env.Codec()
+}
+
 func (req *request) Receive(v interface{}) error {
-	return req.Codec().Decode(req, v)
+	codec := req.Codec()
+	if req.useNumber && codec == encoding.JSON {
+		return req.JSONDecoder().Decode(v)
+	}
+	return codec.Decode(req, v)
+}
+
+func (req *request) JSONDecoder() *json.Decoder {
+	dec := json.NewDecoder(req)
+	if req.useNumber {
+		dec.UseNumber()
+	}
+	return dec
+}
+
+func (req *request) JSONUseNumber(use bool) {
+	req.useNumber = use
+}
+
+func (req *request) ReadBodyInto(buf []byte) (int, error) {
+	if req.Body == nil {
+		return 0, nil
+	}
+
+	n, err := io.ReadFull(req.Body, buf)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return n, nil
+	case nil:
+		m, perr := req.Body.Read(req.probe[:])
+		if m > 0 {
+			// put back the probed byte
+			req.Body = probedBody{
+				Reader: io.MultiReader(bytes.NewReader([]byte{req.probe[0]}), req.Body),
+				Closer: req.Body,
+			}
+			return n, io.ErrShortBuffer
+		}
+		if perr != io.EOF {
+			return n, perr // such as body exceed ServerOption.MaxBodyBytes
+		}
+	}
+	return n, err
+}
+
+func (req *request) BindQuery(v interface{}) error {
+	return bindValues(req.QueryArray, "query", v)
 }
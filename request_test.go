@@ -0,0 +1,119 @@
+package zerver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormVarExpectContinue(t *testing.T) {
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Handle("/form", []string{METHOD_POST}, func(req Request, resp Response) {
+			resp.Write([]byte(req.Vars().FormVar("name") + "," + req.Vars().QueryVar("q")))
+		})
+	})
+
+	tests := []struct {
+		expect string
+	}{
+		{""},
+		{"100-continue"},
+	}
+	for _, tt := range tests {
+		header := http.Header{
+			HEADER_CONTENTTYPE: {"application/x-www-form-urlencoded"},
+			HEADER_EXPECT:      {tt.expect},
+		}
+		w := serve(s, METHOD_POST, "/form?q=1", strings.NewReader("name=zerver"), header)
+		if got := w.Body.String(); got != "zerver,1" {
+			t.Errorf("expect %q: got form %q", tt.expect, got)
+		}
+	}
+}
+
+func TestRequestBodyNotPooled(t *testing.T) {
+	var raw *http.Request
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Handle("/", []string{METHOD_POST}, func(req Request, resp Response) {
+			raw = req.(*request).Request
+		})
+	})
+	serve(s, METHOD_POST, "/", strings.NewReader("first"), nil)
+	first := raw
+	serve(s, METHOD_POST, "/", strings.NewReader("second"), nil)
+
+	// the raw request is retained after handler, it must still read its own body
+	body, err := ioutil.ReadAll(first.Body)
+	if err != nil || string(body) != "first" {
+		t.Fatalf("body of retained request: want %q, got %q %v", "first", body, err)
+	}
+}
+
+func TestReadBodyInto(t *testing.T) {
+	tests := []struct {
+		body string
+		read string
+		rest string
+		err  error
+	}{
+		{"", "", "", nil},
+		{"abc", "abc", "", nil},
+		{"abcd", "abcd", "", nil},
+		{"abcdefg", "abcd", "efg", io.ErrShortBuffer},
+	}
+	for _, tt := range tests {
+		var (
+			read, rest string
+			err        error
+		)
+		s := newTestServer(t, nil, func(rt Router) {
+			rt.Handle("/", []string{METHOD_POST}, func(req Request, resp Response) {
+				buf := make([]byte, 4)
+				var n int
+				n, err = req.ReadBodyInto(buf)
+				read = string(buf[:n])
+				b, _ := ioutil.ReadAll(req)
+				rest = string(b)
+			})
+		})
+		serve(s, METHOD_POST, "/", strings.NewReader(tt.body), nil)
+		if read != tt.read || rest != tt.rest || err != tt.err {
+			t.Errorf("%q: want %q %q %v, got %q %q %v", tt.body, tt.read, tt.rest, tt.err, read, rest, err)
+		}
+	}
+}
+
+func TestReadBodyIntoMaxBodyBytes(t *testing.T) {
+	tests := []struct {
+		body    string
+		tooLong bool
+	}{
+		{"abcd", false},
+		{"abcde", true},
+	}
+	for _, tt := range tests {
+		var (
+			read string
+			err  error
+		)
+		s := newTestServer(t, &ServerOption{MaxBodyBytes: 4}, func(rt Router) {
+			rt.Handle("/", []string{METHOD_POST}, func(req Request, resp Response) {
+				buf := make([]byte, 4)
+				var n int
+				n, err = req.ReadBodyInto(buf)
+				read = string(buf[:n])
+			})
+		})
+		// chunked, the limit is only known while reading
+		r := httptest.NewRequest(METHOD_POST, "/", ioutil.NopCloser(strings.NewReader(tt.body)))
+		r.ContentLength = -1
+		r.TransferEncoding = []string{"chunked"}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		if read != "abcd" || (err != nil) != tt.tooLong || err == io.ErrShortBuffer {
+			t.Errorf("%q: got %q %v", tt.body, read, err)
+		}
+	}
+}
@@ -2,17 +2,31 @@ package zerver
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"io"
+	"mime"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
 
+	"github.com/cosiner/gohper/encoding"
 	"github.com/cosiner/gohper/errors"
 )
 
 const (
-	ErrHijack = errors.Err("Connection not support hijack")
+	ErrHijack          = errors.Err("Connection not support hijack")
+	ErrInvalidCallback = errors.Err("invalid jsonp callback name")
+	ErrIsDirectory     = errors.Err("can't send a directory as file")
+	ErrBudgetExceeded  = errors.Err("route budget exceeded")
 )
 
+// jsonpCallback allow javascript identifiers joined by '.', such as "jQuery.cb_1"
+var jsonpCallback = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*(\.[a-zA-Z_$][a-zA-Z0-9_$]*)*$`)
+
 type (
 	// ResponseWrapper wrap response writer, return another writer and a flag specified
 	// whether should close writer on response destroy, the returned writer must be
@@ -28,9 +42,73 @@ type (
 		Wrap(ResponseWrapper)
 		Headers() http.Header
 		StatusCode(statusCode int) int
+		// Status return current status code, it's the final one after request
+		// done, filters can check it after the chain completed
+		Status() int
+		// BytesWritten return estimated bytes sent to client, it's the size of
+		// status line, headers and body written so far. Headers added by
+		// standard library such as Date, and chunked encoding overhead is not
+		// counted, the body is counted before response writers wrapped by
+		// filters, so it's the uncompressed size if it's compressed.
+		BytesWritten() int64
+		// Written report whether status and headers has been sent, after that,
+		// status code can't be changed
+		Written() bool
 		Value() interface{}
 		SetValue(interface{})
+		// WriteContinue send "100 Continue" interim response, it's sent
+		// automatically when request body is first read, filters can reject
+		// request(such as 401) without reading body, then client will not send it.
+		WriteContinue()
+		// Buffer hold response body in memory until request done, so status
+		// and headers can still be changed after write, such as reply an error
+		// when rendering failed halfway. If body exceed max bytes, buffered data
+		// is sent and later writes go directly, max <= 0 means default 1M.
+		//
+		// Client will not receive anything until request done, don't use it for
+		// streaming or large responses. It must be called before any write.
+		Buffer(max int)
+		// Send encode value by codec and write it, if status is successful, value
+		// is wrapped by Server.SetResponseWrapper first
 		Send(interface{}) error
+		// SendJSONP send value encoded by server codec wrapped in "callback(...)"
+		// as application/javascript, callback must be a valid javascript
+		// identifier, otherwise ErrInvalidCallback is returned and nothing is sent.
+		SendJSONP(callback string, v interface{}) error
+		// DisableContentType remove the default Content-Type setted by
+		// ServerOption.Headers, then it's detected by standard library from body
+		// if not setted later. If ServerOption.NoSniff is enabled,
+		// "X-Content-Type-Options: nosniff" is also setted.
+		DisableContentType()
+		// SetContentType set Content-Type of response, empty type is same as
+		// DisableContentType, the header is left alone for handlers or the
+		// standard library.
+		SetContentType(typ string)
+		// SendFile send file content, Content-Type is detected by file extension
+		// or content, the default Content-Type is ignored.
+		SendFile(name string) error
+		// DisableCompression prevent response from being compressed by filters,
+		// such as already compressed images, it must be called before write
+		DisableCompression()
+		CompressionDisabled() bool
+		// DeclareTrailer announce a trailer by "Trailer" header, it must be
+		// called before any write. Trailers need the response be chunked, so
+		// Content-Length must not be setted, and client must support them.
+		DeclareTrailer(name string)
+		// SetTrailer set value of trailer, it's sent after body. It can be
+		// called at any time before handler return, undeclared trailers are
+		// also sent if it's supported by protocol.
+		SetTrailer(name, value string)
+		// DisableResponseWrapper make Send write value as is, see
+		// Server.SetResponseWrapper
+		DisableResponseWrapper()
+		// ResetWriteDeadline extend write deadline of connection to
+		// ServerOption.WriteTimeout from now, so a long stream isn't killed by
+		// the single timeout as long as client keeps reading. It's a no-op if
+		// WriteTimeout is 0. See route option ResetDeadlineOnFlush.
+		ResetWriteDeadline() error
+		// ResetDeadlineOnFlush make each Flush call ResetWriteDeadline
+		ResetDeadlineOnFlush()
 
 		destroy()
 	}
@@ -39,35 +117,78 @@ type (
 	response struct {
 		Env
 		http.ResponseWriter
+		origin       http.ResponseWriter // writer of standard library, before wrapped
 		status       int
 		statusWrited bool
 		value        interface{}
 		needClose    bool
+		codec        encoding.Codec  // selected by Server.SetCodecSelector
+		budget       context.Context // deadline of Budget route option
+		trailers     []string        // declared trailers
+
+		hijacked   bool
+		noCompress bool
+		noWrap     bool
+		flushReset bool
+
+		written int64 // body bytes
 
-		hijacked bool
+		buffering bool
+		bufferMax int
+		buffer    bytes.Buffer
 	}
 )
 
+const (
+	_DEF_BUFFER_MAX     = 1024 * 1024
+	_BUFFER_REUSE_LIMIT = 64 * 1024 // don't keep too large buffer in pool
+)
+
 // newResponse create a new response, and set default content type to HTML
 func (resp *response) init(env Env, w http.ResponseWriter) Response {
 	resp.Env = env
 	resp.ResponseWriter = w
+	resp.origin = w
 	resp.status = http.StatusOK
 
+	headers := w.Header()
+	for k, v := range env.Server().headers {
+		headers.Set(k, v)
+	}
 	return resp
 }
 
 func (resp *response) destroy() {
+	resp.flushBuffer()
 	resp.flushHeader()
 	resp.statusWrited = false
 	resp.value = nil
+	resp.codec = nil
+	resp.budget = nil
+	resp.trailers = resp.trailers[:0]
+	resp.written = 0
+	if resp.buffer.Cap() > _BUFFER_REUSE_LIMIT {
+		resp.buffer = bytes.Buffer{}
+	}
 
 	if resp.needClose && !resp.hijacked {
-		resp.needClose = false
 		resp.ResponseWriter.(io.Closer).Close()
 	}
+	resp.needClose = false // must be reset even if hijacked, the env is reused
 	resp.hijacked = false
+	resp.noCompress = false
+	resp.noWrap = false
+	resp.flushReset = false
 	resp.ResponseWriter = nil
+	resp.origin = nil
+}
+
+// upgraded mark the connection has been taken over by websocket, response
+// should not write anything
+func (resp *response) upgraded() {
+	resp.stopBuffer()
+	resp.hijacked = true
+	resp.statusWrited = true
 }
 
 func (resp *response) Wrap(fn ResponseWrapper) {
@@ -88,10 +209,20 @@ func (resp *response) StatusCode(statusCode int) int {
 	return resp.status
 }
 
+func (resp *response) Written() bool {
+	return resp.statusWrited
+}
+
 func (resp *response) Status() int {
 	return resp.status
 }
 
+func (resp *response) WriteContinue() {
+	if !resp.statusWrited {
+		resp.ResponseWriter.WriteHeader(http.StatusContinue)
+	}
+}
+
 // Hijack hijack response connection
 func (resp *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, is := resp.ResponseWriter.(http.Hijacker)
@@ -99,15 +230,32 @@ func (resp *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 		return nil, nil, ErrHijack
 	}
 
+	resp.stopBuffer()
 	resp.hijacked = true
 	return hijacker.Hijack()
 }
 
-// Flush flush response's output
+// Flush flush response's output, buffered body is also sent
 func (resp *response) Flush() {
+	resp.flushBuffer()
 	if flusher, is := resp.ResponseWriter.(http.Flusher); is {
 		flusher.Flush()
 	}
+	if resp.flushReset {
+		resp.ResetWriteDeadline()
+	}
+}
+
+func (resp *response) ResetWriteDeadline() error {
+	timeout := resp.Server().options.WriteTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	return http.NewResponseController(resp.origin).SetWriteDeadline(time.Now().Add(timeout))
+}
+
+func (resp *response) ResetDeadlineOnFlush() {
+	resp.flushReset = true
 }
 
 func (resp *response) Headers() http.Header {
@@ -122,11 +270,185 @@ func (resp *response) SetValue(v interface{}) {
 	resp.value = v
 }
 
+func (resp *response) Buffer(max int) {
+	if resp.statusWrited {
+		return
+	}
+	if max <= 0 {
+		max = _DEF_BUFFER_MAX
+	}
+	resp.buffering = true
+	resp.bufferMax = max
+}
+
+// flushBuffer send status, headers and buffered body, then stop buffering
+func (resp *response) flushBuffer() {
+	if !resp.buffering {
+		return
+	}
+
+	resp.buffering = false
+	resp.flushHeader()
+	if resp.buffer.Len() > 0 {
+		resp.write(resp.buffer.Bytes())
+	}
+	resp.buffer.Reset()
+}
+
+// stopBuffer discard buffered body and stop buffering
+func (resp *response) stopBuffer() {
+	resp.buffering = false
+	resp.buffer.Reset()
+}
+
 func (resp *response) Write(data []byte) (i int, err error) {
+	if resp.budget != nil && resp.statusWrited && resp.budget.Err() != nil {
+		return 0, ErrBudgetExceeded
+	}
+	if resp.buffering {
+		if resp.buffer.Len()+len(data) <= resp.bufferMax {
+			return resp.buffer.Write(data)
+		}
+		resp.flushBuffer()
+	}
+
 	resp.flushHeader()
-	return resp.ResponseWriter.Write(data)
+	return resp.write(data)
+}
+
+func (resp *response) write(data []byte) (int, error) {
+	n, err := resp.ResponseWriter.Write(data)
+	resp.written += int64(n)
+	return n, err
+}
+
+func (resp *response) BytesWritten() int64 {
+	if !resp.statusWrited {
+		return 0
+	}
+
+	// status line such as "HTTP/1.1 200 OK\r\n" and the blank line after headers
+	n := len("HTTP/1.1 200 \r\n") + len(http.StatusText(resp.status)) + 2
+	return int64(n+headerSize(resp.Headers())) + resp.written
 }
 
+// Send encode value by server codec and write it, if encode failed, the marshal
+// error handler is called, see Server.SetMarshalErrorHandler
 func (resp *response) Send(v interface{}) error {
-	return resp.Codec().Encode(resp, v)
+	if wrap := resp.Server().respWrapper; wrap != nil && !resp.noWrap && resp.status < http.StatusBadRequest {
+		v = wrap(v)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	if err := resp.Codec().Encode(buf, v); err != nil {
+		resp.Server().marshalError(resp, err)
+		return err
+	}
+
+	_, err := resp.Write(buf.Bytes())
+	return err
+}
+
+func (resp *response) SendJSONP(callback string, v interface{}) error {
+	if len(callback) > 128 || !jsonpCallback.MatchString(callback) {
+		return ErrInvalidCallback
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	buf.WriteString("/**/" + callback + "(") // comment prevent content sniffing attacks
+	if err := resp.Codec().Encode(buf, v); err != nil {
+		resp.Server().marshalError(resp, err)
+		return err
+	}
+	buf.WriteString(");")
+
+	resp.Headers().Set(HEADER_CONTENTTYPE, "application/javascript; charset=utf-8")
+	_, err := resp.Write(buf.Bytes())
+	return err
+}
+
+func (resp *response) Codec() encoding.Codec {
+	if resp.codec != nil {
+		return resp.codec
+	}
+	return resp.Env.Codec()
+}
+
+func (resp *response) DisableContentType() {
+	headers := resp.Headers()
+	headers.Del(HEADER_CONTENTTYPE)
+	if resp.Server().noSniff {
+		headers.Set("X-Content-Type-Options", "nosniff")
+	}
+}
+
+func (resp *response) SetContentType(typ string) {
+	if typ == "" {
+		resp.DisableContentType()
+	} else {
+		resp.Headers().Set(HEADER_CONTENTTYPE, typ)
+	}
+}
+
+func (resp *response) SendFile(name string) error {
+	fd, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		return ErrIsDirectory
+	}
+
+	typ := mime.TypeByExtension(filepath.Ext(name))
+	if typ == "" {
+		var buf [512]byte // http.DetectContentType consider at most 512 bytes
+		n, err := io.ReadFull(fd, buf[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		typ = http.DetectContentType(buf[:n])
+		if _, err = fd.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	resp.DisableContentType()
+	resp.Headers().Set(HEADER_CONTENTTYPE, typ)
+	_, err = io.Copy(resp, fd)
+	return err
+}
+
+func (resp *response) DisableCompression() {
+	resp.noCompress = true
+}
+
+func (resp *response) DisableResponseWrapper() {
+	resp.noWrap = true
+}
+
+func (resp *response) CompressionDisabled() bool {
+	return resp.noCompress
+}
+
+func (resp *response) DeclareTrailer(name string) {
+	name = http.CanonicalHeaderKey(name)
+	resp.trailers = append(resp.trailers, name)
+	resp.Headers().Add("Trailer", name)
+}
+
+func (resp *response) SetTrailer(name, value string) {
+	name = http.CanonicalHeaderKey(name)
+	for _, t := range resp.trailers {
+		if t == name {
+			resp.Headers().Set(name, value)
+			return
+		}
+	}
+	resp.Headers().Set(http.TrailerPrefix+name, value)
 }
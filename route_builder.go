@@ -0,0 +1,234 @@
+package zerver
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/cosiner/gohper/strings2"
+)
+
+type (
+	// RouteBuilder compose method handlers, filters, name and options of a
+	// route in one chained call, it's created by Router.Route, and registered
+	// by Build or at router Init if it's not built. The first error is kept
+	// and returned by Build, calls after Build have no effects.
+	RouteBuilder struct {
+		rt      *router
+		pattern string
+
+		fns     map[string]HandleFunc
+		any     HandleFunc
+		filters []Filter
+		name    string
+		opts    []RouteOption
+
+		built bool
+		err   error
+	}
+
+	// routeHandler is the handler built by RouteBuilder, filters of it only
+	// applied to this route, after the router filters
+	routeHandler struct {
+		pattern string
+		fns     map[string]HandleFunc
+		any     HandleFunc
+		filters []Filter
+	}
+)
+
+func newRouteBuilder(rt *router, pattern string) *RouteBuilder {
+	return &RouteBuilder{
+		rt:      rt,
+		pattern: pattern,
+		fns:     make(map[string]HandleFunc),
+	}
+}
+
+func (b *RouteBuilder) setErr(err error) *RouteBuilder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+// Method register handle func for method, registering a method twice or
+// after Any is an error
+func (b *RouteBuilder) Method(method string, fn HandleFunc) *RouteBuilder {
+	if b.built {
+		return b
+	}
+	if fn == nil {
+		panic("nil handle func is not allowed")
+	}
+
+	method = MethodName(method)
+	if b.any != nil || b.fns[method] != nil {
+		return b.setErr(ErrMethodExists)
+	}
+	b.fns[method] = fn
+	return b
+}
+
+func (b *RouteBuilder) Get(fn HandleFunc) *RouteBuilder {
+	return b.Method(METHOD_GET, fn)
+}
+
+func (b *RouteBuilder) Post(fn HandleFunc) *RouteBuilder {
+	return b.Method(METHOD_POST, fn)
+}
+
+func (b *RouteBuilder) Put(fn HandleFunc) *RouteBuilder {
+	return b.Method(METHOD_PUT, fn)
+}
+
+func (b *RouteBuilder) Patch(fn HandleFunc) *RouteBuilder {
+	return b.Method(METHOD_PATCH, fn)
+}
+
+func (b *RouteBuilder) Delete(fn HandleFunc) *RouteBuilder {
+	return b.Method(METHOD_DELETE, fn)
+}
+
+// Any register handle func for all methods, it conflicts with other methods
+func (b *RouteBuilder) Any(fn HandleFunc) *RouteBuilder {
+	if b.built {
+		return b
+	}
+	if fn == nil {
+		panic("nil handle func is not allowed")
+	}
+
+	if b.any != nil || len(b.fns) != 0 {
+		return b.setErr(ErrMethodExists)
+	}
+	b.any = fn
+	return b
+}
+
+// Filters add filters only applied to this route, they are run in order after
+// filters registered on router
+func (b *RouteBuilder) Filters(filters ...Filter) *RouteBuilder {
+	if !b.built {
+		b.filters = append(b.filters, filters...)
+	}
+	return b
+}
+
+// Name name the route for Router.Reverse, names must be unique in a router
+func (b *RouteBuilder) Name(name string) *RouteBuilder {
+	if !b.built {
+		b.name = name
+	}
+	return b
+}
+
+// Consumes is the route option Consumes
+func (b *RouteBuilder) Consumes(types ...string) *RouteBuilder {
+	return b.Options(Consumes(types...))
+}
+
+// Produces is the route option Produces
+func (b *RouteBuilder) Produces(types ...string) *RouteBuilder {
+	return b.Options(Produces(types...))
+}
+
+// Options add route options
+func (b *RouteBuilder) Options(opts ...RouteOption) *RouteBuilder {
+	if !b.built {
+		b.opts = append(b.opts, opts...)
+	}
+	return b
+}
+
+// Build register the route to router, it's called by router Init for builders
+// haven't been built
+func (b *RouteBuilder) Build() error {
+	if b.built {
+		return b.err
+	}
+	b.built = true
+
+	if b.err == nil && b.any == nil && len(b.fns) == 0 {
+		b.err = ErrNoRouteMethods
+	}
+	if b.err == nil && b.name != "" {
+		if _, has := b.rt.names[b.name]; has {
+			b.err = ErrRouteNameExists
+		}
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	h := &routeHandler{
+		pattern: b.pattern,
+		fns:     make(map[string]HandleFunc, len(b.fns)),
+		filters: b.filters,
+	}
+	for m, fn := range b.fns {
+		h.fns[m] = Intercept(fn, b.filters...)
+	}
+	if b.any != nil {
+		h.any = Intercept(b.any, b.filters...)
+	}
+
+	b.err = b.rt.Handler(b.pattern, h, b.opts...)
+	if b.err == nil && b.name != "" {
+		if b.rt.names == nil {
+			b.rt.names = make(map[string]string)
+		}
+		b.rt.names[b.name] = b.pattern
+	}
+	return b.err
+}
+
+func (h *routeHandler) Init(env Env) (err error) {
+	for i := 0; i < len(h.filters) && err == nil; i++ {
+		err = initComponent(env, "route filter", h.pattern, h.filters[i])
+	}
+	return err
+}
+
+func (h *routeHandler) Destroy() {
+	for _, f := range h.filters {
+		f.Destroy()
+	}
+}
+
+func (h *routeHandler) Handler(method string) HandleFunc {
+	if fn := h.fns[method]; fn != nil {
+		return fn
+	}
+	return h.any
+}
+
+// reverse replace variables of pattern with values in order, values of named
+// variables are escaped, catch-all values are used as is
+func reverse(pattern string, vals []string) (string, error) {
+	pattern = strings2.TrimAfter(pattern, "?")
+	sections := strings.Split(pattern, "/")
+
+	var n int
+	for i, s := range sections {
+		at := strings.LastIndexAny(s, string([]byte{_MATCH_WILDCARD, _MATCH_REMAINSALL}))
+		if at < 0 {
+			continue
+		}
+		if n >= len(vals) {
+			return "", ErrReverseVars
+		}
+
+		val := vals[n]
+		if s[at] == _MATCH_WILDCARD {
+			val = url.PathEscape(val)
+		} else {
+			val = strings.TrimPrefix(val, "/")
+		}
+		sections[i] = s[:at] + val
+		n++
+	}
+	if n != len(vals) {
+		return "", ErrReverseVars
+	}
+	return strings.Join(sections, "/"), nil
+}
@@ -0,0 +1,94 @@
+package zerver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReverse(t *testing.T) {
+	tests := []struct {
+		pattern string
+		vals    []string
+		path    string
+		err     error
+	}{
+		{"/users", nil, "/users", nil},
+		{"/users/:id", []string{"1"}, "/users/1", nil},
+		{"/users/:id", []string{"a b/c"}, "/users/a%20b%2Fc", nil},
+		{"/users/:id/posts/:", []string{"1", "2"}, "/users/1/posts/2", nil},
+		{"/files/*path", []string{"/a/b.txt"}, "/files/a/b.txt", nil},
+		{"/file.:ext", []string{"go"}, "/file.go", nil},
+		{"/search?q", nil, "/search", nil},
+		{"/users/:id", nil, "", ErrReverseVars},
+		{"/users/:id", []string{"1", "2"}, "", ErrReverseVars},
+	}
+	for _, tt := range tests {
+		path, err := reverse(tt.pattern, tt.vals)
+		if path != tt.path || err != tt.err {
+			t.Errorf("%q %v: got %q %v, expect %q %v", tt.pattern, tt.vals, path, err, tt.path, tt.err)
+		}
+	}
+}
+
+func TestRouteBuilder(t *testing.T) {
+	var filtered int
+	filter := FilterFunc(func(req Request, resp Response, chain FilterChain) {
+		filtered++
+		chain(req, resp)
+	})
+	write := func(s string) HandleFunc {
+		return func(req Request, resp Response) { resp.Write([]byte(s)) }
+	}
+
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Route("/users/:id").Get(write("get")).Put(write("put")).Filters(filter).Name("user")
+		rt.Route("/any").Any(write("any"))
+	})
+
+	tests := []struct {
+		method, url string
+		status      int
+		body        string
+	}{
+		{METHOD_GET, "/users/1", http.StatusOK, "get"},
+		{METHOD_PUT, "/users/1", http.StatusOK, "put"},
+		{METHOD_POST, "/users/1", http.StatusMethodNotAllowed, ""},
+		{METHOD_DELETE, "/any", http.StatusOK, "any"},
+	}
+	for _, tt := range tests {
+		w := serve(s, tt.method, tt.url, nil, nil)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s %s: got %d %q, expect %d %q", tt.method, tt.url, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+	if filtered != 2 {
+		t.Errorf("route filter run %d times, expect 2", filtered)
+	}
+	if path, err := s.Router.Reverse("user", "1"); path != "/users/1" || err != nil {
+		t.Errorf("reverse user: %q %v", path, err)
+	}
+	if _, err := s.Router.Reverse("none"); err != ErrRouteNotFound {
+		t.Errorf("reverse unknown name: %v", err)
+	}
+}
+
+func TestRouteBuilderConflicts(t *testing.T) {
+	rt := NewRouter()
+	tests := []struct {
+		b   *RouteBuilder
+		err error
+	}{
+		{rt.Route("/a").Get(NopHandleFunc).Method("get", NopHandleFunc), ErrMethodExists},
+		{rt.Route("/b").Get(NopHandleFunc).Any(NopHandleFunc), ErrMethodExists},
+		{rt.Route("/c").Any(NopHandleFunc).Post(NopHandleFunc), ErrMethodExists},
+		{rt.Route("/d"), ErrNoRouteMethods},
+		{rt.Route("/e").Get(NopHandleFunc).Name("e"), nil},
+		{rt.Route("/f").Get(NopHandleFunc).Name("e"), ErrRouteNameExists},
+		{rt.Route("/e").Post(NopHandleFunc), ErrHandlerExists},
+	}
+	for i, tt := range tests {
+		if err := tt.b.Build(); err != tt.err {
+			t.Errorf("%d: err %v, expect %v", i, err, tt.err)
+		}
+	}
+}
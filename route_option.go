@@ -0,0 +1,296 @@
+package zerver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// RouteOption config a handler route at registration
+	RouteOption func(*routeOption)
+
+	routeOption struct {
+		consumes []string
+		produces []string
+		timeout  time.Duration
+		budget   bool
+
+		buffered  bool
+		bufferMax int
+
+		noCompress bool
+		noWrap     bool
+		flushReset bool
+		noAuto     []string // methods opted out of AutoHEAD and AutoOPTIONS
+	}
+
+	// optionHandler wrap a handler with route options, all options is checked
+	// before handle function is invoked, after filters
+	optionHandler struct {
+		handler Handler
+		opt     routeOption
+	}
+)
+
+// Consumes limit acceptable request content types, "type/*" and "*/*" is
+// supported. Request with other Content-Type will be rejected with 415.
+func Consumes(types ...string) RouteOption {
+	return func(o *routeOption) {
+		o.consumes = append(o.consumes, types...)
+	}
+}
+
+// Produces declare response content types of this route, request whose Accept
+// header don't match any of them will be rejected with 406. The negotiated type
+// will be setted as response Content-Type, it override the default one setted
+// by ServerOption.Headers.
+func Produces(types ...string) RouteOption {
+	return func(o *routeOption) {
+		o.produces = append(o.produces, types...)
+	}
+}
+
+// Timeout set a deadline to Request.Context() of this route, it's applied
+// before root filters. Handlers should watch the context and return once it's
+// done, if nothing was written after that, 504 is replied with an error body.
+//
+// The global ServerOption.WriteTimeout is still effective, if it's shorter,
+// the connection will be closed before the route deadline.
+func Timeout(timeout time.Duration) RouteOption {
+	return func(o *routeOption) {
+		o.timeout = timeout
+	}
+}
+
+// Budget is a soft Timeout for streaming routes such as progress and long
+// polls, if the deadline exceeded after something written, further writes get
+// ErrBudgetExceeded, the written part is flushed and the response is ended
+// normally instead of an error. If nothing was written, it's same as Timeout.
+func Budget(budget time.Duration) RouteOption {
+	return func(o *routeOption) {
+		o.timeout = budget
+		o.budget = true
+	}
+}
+
+// Buffered make response of this route buffered, see Response.Buffer
+func Buffered(max int) RouteOption {
+	return func(o *routeOption) {
+		o.buffered = true
+		o.bufferMax = max
+	}
+}
+
+// NoCompression disable response compression of this route, see
+// Response.DisableCompression
+func NoCompression() RouteOption {
+	return func(o *routeOption) {
+		o.noCompress = true
+	}
+}
+
+// NoResponseWrapper opt out the response wrapper for this route, see
+// Server.SetResponseWrapper
+func NoResponseWrapper() RouteOption {
+	return func(o *routeOption) {
+		o.noWrap = true
+	}
+}
+
+// ResetDeadlineOnFlush extend the connection write deadline on each flush of
+// this route, it's for streaming responses, see Response.ResetWriteDeadline
+func ResetDeadlineOnFlush() RouteOption {
+	return func(o *routeOption) {
+		o.flushReset = true
+	}
+}
+
+// NoAutoMethods opt out the automatic HEAD or OPTIONS of this route, see
+// ServerOption.AutoHEAD and AutoOPTIONS, if methods is empty, both are disabled
+func NoAutoMethods(methods ...string) RouteOption {
+	if len(methods) == 0 {
+		methods = []string{METHOD_HEAD, METHOD_OPTIONS}
+	}
+	return func(o *routeOption) {
+		for _, m := range methods {
+			o.noAuto = append(o.noAuto, MethodName(m))
+		}
+	}
+}
+
+// routeTimeout return timeout setted by route option, 0 means no timeout
+func routeTimeout(h Handler) time.Duration {
+	if oh, is := h.(*optionHandler); is {
+		return oh.opt.timeout
+	}
+	return 0
+}
+
+// routeProduces report whether route has Produces option
+func routeProduces(h Handler) bool {
+	if oh, is := h.(*optionHandler); is {
+		return len(oh.opt.produces) != 0
+	}
+	return false
+}
+
+// routeAutoMethod report whether the automatic method is not opted out by route
+func routeAutoMethod(h Handler, method string) bool {
+	if oh, is := h.(*optionHandler); is {
+		for _, m := range oh.opt.noAuto {
+			if m == method {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// routeBudget report whether route timeout is a budget
+func routeBudget(h Handler) bool {
+	if oh, is := h.(*optionHandler); is {
+		return oh.opt.budget
+	}
+	return false
+}
+
+func newOptionHandler(h Handler, opts []RouteOption) Handler {
+	if len(opts) == 0 {
+		return h
+	}
+
+	oh := &optionHandler{handler: h}
+	for _, opt := range opts {
+		opt(&oh.opt)
+	}
+	return oh
+}
+
+func (h *optionHandler) Init(env Env) error {
+	return h.handler.Init(env)
+}
+
+func (h *optionHandler) Destroy() {
+	h.handler.Destroy()
+}
+
+func (h *optionHandler) Handler(method string) HandleFunc {
+	fn := h.handler.Handler(method)
+	if fn == nil {
+		return nil
+	}
+
+	return func(req Request, resp Response) {
+		if !h.opt.consume(req) {
+			resp.StatusCode(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if len(h.opt.produces) != 0 {
+			typ := negotiate(req.GetHeader(HEADER_ACCEPT), h.opt.produces)
+			if typ == "" {
+				resp.StatusCode(http.StatusNotAcceptable)
+				return
+			}
+			resp.Headers().Set(HEADER_CONTENTTYPE, typ)
+		}
+
+		if h.opt.noCompress {
+			resp.DisableCompression()
+		}
+		if h.opt.noWrap {
+			resp.DisableResponseWrapper()
+		}
+		if h.opt.flushReset {
+			resp.ResetDeadlineOnFlush()
+		}
+		if h.opt.buffered {
+			resp.Buffer(h.opt.bufferMax)
+		}
+		fn(req, resp)
+	}
+}
+
+func (o *routeOption) consume(req Request) bool {
+	if len(o.consumes) == 0 {
+		return true
+	}
+
+	typ := mediaType(req.GetHeader(HEADER_CONTENTTYPE))
+	if typ == "" {
+		// request without body
+		m := req.ReqMethod()
+		return m != METHOD_POST && m != METHOD_PUT && m != METHOD_PATCH
+	}
+
+	for _, c := range o.consumes {
+		if mediaMatch(c, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType return the lower-case media type without parameters
+func mediaType(s string) string {
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// mediaMatch check whether media range such as "*/*", "text/*" matches type
+func mediaMatch(mrange, typ string) bool {
+	mrange = mediaType(mrange)
+	if mrange == "*/*" || mrange == typ {
+		return true
+	}
+
+	if strings.HasSuffix(mrange, "/*") {
+		return strings.HasPrefix(typ, mrange[:len(mrange)-1])
+	}
+	return false
+}
+
+type acceptRange struct {
+	typ string
+	q   float64
+}
+
+// negotiate return the first offer matched the media range which has highest
+// quality in accept header, if accept is empty, the first offer is returned
+func negotiate(accept string, offers []string) string {
+	if accept == "" {
+		return offers[0]
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		r := acceptRange{typ: mediaType(part), q: 1}
+		if i := strings.Index(part, "q="); i >= 0 {
+			q, err := strconv.ParseFloat(strings.TrimSpace(mediaType(part[i+2:])), 64)
+			if err == nil {
+				r.q = q
+			}
+		}
+		if r.typ != "" && r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		for _, o := range offers {
+			if mediaMatch(r.typ, mediaType(o)) {
+				return o
+			}
+		}
+	}
+	return ""
+}
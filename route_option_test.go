@@ -0,0 +1,114 @@
+package zerver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMediaMatch(t *testing.T) {
+	tests := []struct {
+		mrange, typ string
+		match       bool
+	}{
+		{"*/*", "text/html", true},
+		{"text/*", "text/html", true},
+		{"text/*", "application/json", false},
+		{"application/json", "application/json", true},
+		{"Application/JSON; charset=utf-8", "application/json", true},
+		{"application/json", "application/xml", false},
+	}
+	for _, tt := range tests {
+		if m := mediaMatch(tt.mrange, tt.typ); m != tt.match {
+			t.Errorf("%q %q: match %t, expect %t", tt.mrange, tt.typ, m, tt.match)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "application/xml", "text/plain; charset=utf-8"}
+	tests := []struct {
+		accept string
+		typ    string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/*", "text/plain; charset=utf-8"},
+		{"application/xml;q=0.5, text/plain", "text/plain; charset=utf-8"},
+		{"application/xml;q=0.9, application/json;q=0.8", "application/xml"},
+		{"application/json;q=0, application/xml", "application/xml"},
+		{"image/png", ""},
+		{"application/json;q=0", ""},
+	}
+	for _, tt := range tests {
+		if typ := negotiate(tt.accept, offers); typ != tt.typ {
+			t.Errorf("%q: negotiated %q, expect %q", tt.accept, typ, tt.typ)
+		}
+	}
+}
+
+func TestConsumesProduces(t *testing.T) {
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Handle("/", []string{METHOD_GET, METHOD_POST}, func(req Request, resp Response) {},
+			Consumes("application/json"), Produces("application/json", "text/plain"))
+	})
+
+	tests := []struct {
+		method, ctype, accept string
+		status                int
+		resp                  string
+	}{
+		{METHOD_GET, "", "", http.StatusOK, "application/json"},
+		{METHOD_GET, "", "text/*", http.StatusOK, "text/plain"},
+		{METHOD_GET, "", "text/html", http.StatusNotAcceptable, ""},
+		{METHOD_GET, "", "image/png", http.StatusNotAcceptable, ""},
+		{METHOD_POST, "application/json; charset=utf-8", "", http.StatusOK, "application/json"},
+		{METHOD_POST, "text/plain", "", http.StatusUnsupportedMediaType, ""},
+		{METHOD_POST, "", "", http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.ctype != "" {
+			header.Set(HEADER_CONTENTTYPE, tt.ctype)
+		}
+		if tt.accept != "" {
+			header.Set(HEADER_ACCEPT, tt.accept)
+		}
+		w := serve(s, tt.method, "/", strings.NewReader(""), header)
+		if w.Code != tt.status {
+			t.Errorf("%s %q %q: status %d, expect %d", tt.method, tt.ctype, tt.accept, w.Code, tt.status)
+		}
+		if tt.resp != "" && w.Header().Get(HEADER_CONTENTTYPE) != tt.resp {
+			t.Errorf("%s %q %q: content type %q, expect %q", tt.method, tt.ctype, tt.accept, w.Header().Get(HEADER_CONTENTTYPE), tt.resp)
+		}
+	}
+}
+
+func TestNoAutoMethods(t *testing.T) {
+	s := newTestServer(t, &ServerOption{AutoHEAD: true, AutoOPTIONS: true}, func(rt Router) {
+		get := func(req Request, resp Response) {}
+		rt.Handle("/auto", []string{METHOD_GET}, get)
+		rt.Handle("/nohead", []string{METHOD_GET}, get, NoAutoMethods(METHOD_HEAD))
+		rt.Handle("/none", []string{METHOD_GET}, get, NoAutoMethods())
+	})
+
+	tests := []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{METHOD_HEAD, "/auto", http.StatusOK, ""},
+		{METHOD_OPTIONS, "/auto", http.StatusNoContent, "GET, HEAD, OPTIONS"},
+		{METHOD_HEAD, "/nohead", http.StatusMethodNotAllowed, "GET, OPTIONS"},
+		{METHOD_OPTIONS, "/nohead", http.StatusNoContent, "GET, OPTIONS"},
+		{METHOD_HEAD, "/none", http.StatusMethodNotAllowed, "GET"},
+		{METHOD_OPTIONS, "/none", http.StatusMethodNotAllowed, "GET"},
+	}
+	for _, tt := range tests {
+		w := serve(s, tt.method, tt.path, nil, nil)
+		if w.Code != tt.code || w.Header().Get(HEADER_ALLOW) != tt.allow {
+			t.Errorf("%s %s: want %d %q, got %d %q", tt.method, tt.path, tt.code, tt.allow, w.Code, w.Header().Get(HEADER_ALLOW))
+		}
+	}
+}
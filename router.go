@@ -3,7 +3,9 @@ package zerver
 import (
 	"io"
 	"log"
+	"net"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/cosiner/gohper/errors"
@@ -16,7 +18,12 @@ var (
 		" or catchall at the same position, " +
 		"this means one of them will nerver be matched, " +
 		"please check your routes")
-	ErrHandlerExists = errors.New("pattern handler already exists.")
+	ErrHandlerExists   = errors.New("pattern handler already exists.")
+	ErrMethodExists    = errors.New("method handler already exists.")
+	ErrNoRouteMethods  = errors.New("no method handlers for route.")
+	ErrRouteNameExists = errors.New("route name already exists.")
+	ErrRouteNotFound   = errors.New("there is no route of the name.")
+	ErrReverseVars     = errors.New("count of values don't match route variables.")
 )
 
 type (
@@ -24,15 +31,41 @@ type (
 		Component
 
 		PrintRouteTree(w io.Writer)
+		// Routes return all registered routes sorted by pattern, it's a snapshot
+		// for introspection such as api documentation, handlers in it should
+		// not be modified or called
+		Routes() []RouteInfo
 
 		Filter(pattern string, f Filter) error
 		FilterFunc(pattern string, f FilterFunc) error
-		Handler(pattern string, h Handler) error
+		// Handler register a handler, options will be applied to this route only
+		Handler(pattern string, h Handler, opts ...RouteOption) error
+		// Handle register handle func for these methods, others will get 405
+		Handle(pattern string, methods []string, fn HandleFunc, opts ...RouteOption) error
+		// Any register handle func for all methods
+		Any(pattern string, fn HandleFunc, opts ...RouteOption) error
+		// Fallback register a handler for unmatched paths under prefix, such as
+		// single page applications. Exact routes always take precedence, then
+		// the fallback of longest prefix, finally the global 404.
+		Fallback(prefix string, h Handler) error
 		TaskHandler(pattern string, th TaskHandler) error
 		WsHandler(pattern string, th WsConn) error
+		// Host return the router of host, routes registered on it only match
+		// requests of that host, requests of other hosts use this one. If
+		// request host has port and there is no router for it, the port is
+		// ignored. Filters of this router are not applied to host routers, see
+		// also Server.HostFilter. Task handlers are not host aware.
+		Host(host string) Router
+
+		// Route start a fluent registration of pattern, see RouteBuilder
+		Route(pattern string) *RouteBuilder
+		// Reverse build url path of the route named by RouteBuilder.Name, vals
+		// replace route variables in order, names of host routers are not
+		// included
+		Reverse(name string, vals ...string) (string, error)
 
 		MatchHandlerFilters(url *url.URL) (Handler, ReqVars, []Filter)
-		MatchWebSocketHandler(url *url.URL) (WsHandler, ReqVars)
+		MatchWebSocketHandler(url *url.URL) (WsHandler, ReqVars, []Filter)
 		MatchTaskHandler(url *url.URL) TaskHandler
 	}
 
@@ -58,6 +91,29 @@ type (
 		children []*router // child routers
 		noFilter bool
 		routeProcessor
+
+		fallbacks []fallback         // only used by root, sorted by prefix length desc
+		hosts     map[string]*router // only used by root, host:router
+		builders  []*RouteBuilder    // only used by root, built at Init
+		names     map[string]string  // only used by root, route name:pattern
+	}
+
+	// RouteInfo describe a registered route
+	RouteInfo struct {
+		// host of route, only setted by host routers
+		Host    string
+		Pattern string
+		// "handler", "websocket", "task" or "fallback"
+		Kind string
+		// standard methods served by handler, only for "handler" and "fallback"
+		Methods []string
+		// the registered handler, route options are unwrapped
+		Handler interface{}
+	}
+
+	fallback struct {
+		prefix  string
+		handler Handler
 	}
 )
 
@@ -70,22 +126,39 @@ func NewRouter() Router {
 }
 
 func (rt *router) Init(env Env) (err error) {
+	for i := 0; i < len(rt.builders) && err == nil; i++ {
+		err = rt.builders[i].Build()
+	}
+	rt.builders = nil
+	if err != nil {
+		return err
+	}
+
 	if rt.handler != nil {
-		err = rt.handler.Init(env)
+		err = initComponent(env, "handler", rt.handlerPattern, rt.handler)
 	}
 
 	for i := 0; i < len(rt.filters) && err == nil; i++ {
-		err = rt.filters[i].Init(env)
+		err = initComponent(env, "filter", "", rt.filters[i])
 	}
 	if err == nil && rt.wsHandler != nil {
-		err = rt.wsHandler.Init(env)
+		err = initComponent(env, "websocket handler", rt.wsHandlerPattern, rt.wsHandler)
 	}
 	if err == nil && rt.taskHandler != nil {
-		err = rt.taskHandler.Init(env)
+		err = initComponent(env, "task handler", rt.taskHandlerPattern, rt.taskHandler)
 	}
 	for i := 0; i < len(rt.children) && err == nil; i++ {
 		err = rt.children[i].Init(env)
 	}
+	for i := 0; i < len(rt.fallbacks) && err == nil; i++ {
+		err = initComponent(env, "fallback handler", rt.fallbacks[i].prefix, rt.fallbacks[i].handler)
+	}
+	for _, h := range rt.hosts {
+		if err != nil {
+			break
+		}
+		err = h.Init(env)
+	}
 
 	return
 }
@@ -107,6 +180,40 @@ func (rt *router) Destroy() {
 	for _, c := range rt.children {
 		c.Destroy()
 	}
+	for _, f := range rt.fallbacks {
+		f.handler.Destroy()
+	}
+	for _, h := range rt.hosts {
+		h.Destroy()
+	}
+}
+
+func (rt *router) Host(host string) Router {
+	if h := rt.hosts[host]; h != nil {
+		return h
+	}
+
+	h := NewRouter().(*router)
+	if rt.hosts == nil {
+		rt.hosts = make(map[string]*router)
+	}
+	rt.hosts[host] = h
+	return h
+}
+
+// hostRouter return the router of host, nil if there is no one for it
+func (rt *router) hostRouter(host string) *router {
+	if len(rt.hosts) == 0 {
+		return nil
+	}
+
+	if h := rt.hosts[host]; h != nil {
+		return h
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return rt.hosts[h]
+	}
+	return nil
 }
 
 func (rt *router) FilterFunc(pattern string, f FilterFunc) error {
@@ -117,8 +224,141 @@ func (rt *router) Filter(pattern string, f Filter) error {
 	return rt.register(pattern, f)
 }
 
-func (rt *router) Handler(pattern string, h Handler) error {
-	return rt.register(pattern, h)
+func (rt *router) Handler(pattern string, h Handler, opts ...RouteOption) error {
+	if h == nil {
+		return rt.register(pattern, nil)
+	}
+	return rt.register(pattern, newOptionHandler(h, opts))
+}
+
+func (rt *router) Handle(pattern string, methods []string, fn HandleFunc, opts ...RouteOption) error {
+	if len(methods) == 0 {
+		panic("no methods for handle func")
+	}
+	return rt.Handler(pattern, newMethodsHandler(methods, fn), opts...)
+}
+
+func (rt *router) Any(pattern string, fn HandleFunc, opts ...RouteOption) error {
+	return rt.Handler(pattern, newMethodsHandler(nil, fn), opts...)
+}
+
+func (rt *router) Route(pattern string) *RouteBuilder {
+	b := newRouteBuilder(rt, pattern)
+	rt.builders = append(rt.builders, b)
+	return b
+}
+
+func (rt *router) Reverse(name string, vals ...string) (string, error) {
+	pattern, has := rt.names[name]
+	if !has {
+		return "", ErrRouteNotFound
+	}
+	return reverse(pattern, vals)
+}
+
+func (rt *router) Fallback(prefix string, h Handler) error {
+	if h == nil {
+		panic("nil fallback handler is not allowed")
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	i := 0
+	for ; i < len(rt.fallbacks); i++ {
+		p := rt.fallbacks[i].prefix
+		if p == prefix {
+			return ErrHandlerExists
+		}
+		if len(p) < len(prefix) {
+			break
+		}
+	}
+	rt.fallbacks = append(rt.fallbacks, fallback{})
+	copy(rt.fallbacks[i+1:], rt.fallbacks[i:])
+	rt.fallbacks[i] = fallback{prefix: prefix, handler: h}
+	return nil
+}
+
+// matchFallback return handler of the longest prefix matched path
+func (rt *router) matchFallback(path string) *fallback {
+	for i := range rt.fallbacks {
+		f := &rt.fallbacks[i]
+		if strings.HasPrefix(path, f.prefix) &&
+			(len(path) == len(f.prefix) || path[len(f.prefix)] == '/') {
+			return f
+		}
+	}
+	return nil
+}
+
+func (rt *router) Routes() []RouteInfo {
+	routes := rt.routes(nil)
+	for _, f := range rt.fallbacks {
+		routes = append(routes, RouteInfo{
+			Pattern: f.prefix,
+			Kind:    "fallback",
+			Methods: handlerMethods(f.handler),
+			Handler: f.handler,
+		})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+
+	hosts := make([]string, 0, len(rt.hosts))
+	for host := range rt.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		for _, route := range rt.hosts[host].Routes() {
+			route.Host = host
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+func (rt *router) routes(routes []RouteInfo) []RouteInfo {
+	if rt.handler != nil {
+		h := rt.handler
+		if oh, is := h.(*optionHandler); is {
+			h = oh.handler
+		}
+		routes = append(routes, RouteInfo{
+			Pattern: rt.handlerPattern,
+			Kind:    "handler",
+			Methods: handlerMethods(h),
+			Handler: h,
+		})
+	}
+	if rt.wsHandler != nil {
+		routes = append(routes, RouteInfo{
+			Pattern: rt.wsHandlerPattern,
+			Kind:    "websocket",
+			Handler: rt.wsHandler,
+		})
+	}
+	if rt.taskHandler != nil {
+		routes = append(routes, RouteInfo{
+			Pattern: rt.taskHandlerPattern,
+			Kind:    "task",
+			Handler: rt.taskHandler,
+		})
+	}
+	for _, c := range rt.children {
+		routes = c.routes(routes)
+	}
+	return routes
+}
+
+func handlerMethods(h Handler) []string {
+	var methods []string
+	for _, m := range routeMethods {
+		if h.Handler(m) != nil {
+			methods = append(methods, m)
+		}
+	}
+	return methods
 }
 
 func (rt *router) TaskHandler(pattern string, th TaskHandler) error {
@@ -135,6 +375,11 @@ func (rt *router) register(pattern string, processor interface{}) error {
 	}
 
 	routePath, pathVars := compile(pattern)
+	if !slashSignificant(processor) {
+		if l := len(routePath); l != 1 && routePath[l-1] == '/' {
+			routePath = routePath[:l-1]
+		}
+	}
 	if r, is := processor.(*router); is {
 		if !rt.addPathRouter(routePath, r) {
 			return ErrHandlerExists
@@ -175,20 +420,42 @@ func (rt *router) register(pattern string, processor interface{}) error {
 		}
 		nrt.taskHandler = th
 		nrt.taskHandlerVars = pathVars
+		nrt.taskHandlerPattern = pattern
 		return nil
 	}
 	panic("unreachable")
 }
 
-func (rt *router) MatchWebSocketHandler(url *url.URL) (WsHandler, ReqVars) {
-	path := url.Path
-	vars := ReqVars{}
-	rt, vars.urlVals = rt.matchOne(path, vars.urlVals)
+// slashSignificant report whether trailing slash of pattern is kept for the
+// processor, only routes keep it, filters and sub-routers always cover the
+// path with or without it, so "/admin/" filter also apply to "/admin"
+func slashSignificant(processor interface{}) bool {
+	if _, is := processor.(*router); is {
+		return false
+	}
+	switch processor.(type) {
+	case Handler, WsHandler, TaskHandler:
+		return true
+	}
+	return false
+}
+
+func (rt *router) MatchWebSocketHandler(url *url.URL) (WsHandler, ReqVars, []Filter) {
+	if h := rt.hostRouter(url.Host); h != nil {
+		return h.MatchWebSocketHandler(url)
+	}
+
+	var (
+		vars    ReqVars
+		filters []Filter
+	)
+	rt, vars.urlVals, filters = rt.matchFilters(url.Path)
 	if rt == nil || rt.wsHandler == nil {
-		return nil, vars
+		return nil, vars, filters
 	}
+	vars.pattern = rt.wsHandlerPattern
 	vars.urlVars = rt.wsHandlerVars
-	return rt.wsHandler, vars
+	return rt.wsHandler, vars, filters
 }
 
 func (rt *router) MatchTaskHandler(url *url.URL) TaskHandler {
@@ -214,33 +481,55 @@ func (rt *router) MatchTaskHandler(url *url.URL) TaskHandler {
 // }
 
 func (rt *router) MatchHandlerFilters(url *url.URL) (Handler, ReqVars, []Filter) {
+	if h := rt.hostRouter(url.Host); h != nil {
+		return h.MatchHandlerFilters(url)
+	}
+
 	var (
-		path    = url.Path
 		vars    ReqVars
 		filters []Filter
+		root    = rt
 	)
-
-	if rt.noFilter {
-		rt, vars.urlVals = rt.matchOne(path, vars.urlVals)
-	} else {
-		pathIndex, continu := 0, true
-		for continu {
-			if fs := rt.filters; len(fs) != 0 {
-				if filters == nil {
-					filters = make([]Filter, 0, 3)
-				}
-				filters = append(filters, fs...)
-			}
-			pathIndex, vars.urlVals, rt, continu = rt.matchMultiple(path, pathIndex, vars.urlVals)
-		}
-	}
+	rt, vars.urlVals, filters = rt.matchFilters(url.Path)
 	if rt == nil || rt.handler == nil {
+		if f := root.matchFallback(url.Path); f != nil {
+			vars.urlVals = nil
+			vars.pattern = f.prefix
+			return f.handler, vars, filters
+		}
 		return nil, vars, filters
 	}
+	vars.pattern = rt.handlerPattern
 	vars.urlVars = rt.handlerVars
 	return rt.handler, vars, filters
 }
 
+// matchFilters match the final route node for path, and collect all filters
+// along the path
+func (rt *router) matchFilters(path string) (*router, []string, []Filter) {
+	var (
+		values  []string
+		filters []Filter
+	)
+
+	if rt.noFilter {
+		rt, values = rt.matchOne(path, values)
+		return rt, values, nil
+	}
+
+	pathIndex, continu := 0, true
+	for continu {
+		if fs := rt.filters; len(fs) != 0 {
+			if filters == nil {
+				filters = make([]Filter, 0, 3)
+			}
+			filters = append(filters, fs...)
+		}
+		pathIndex, values, rt, continu = rt.matchMultiple(path, pathIndex, values)
+	}
+	return rt, values, filters
+}
+
 // addPath add an new path to route, use given function to operate the final
 // route node for this path
 func (rt *router) addPath(path string) (*router, bool) {
@@ -542,19 +831,15 @@ var (
 // for '*', it will catch all remains url path, it should appear in the last
 // of pattern for variables behind it will all be ignored
 //
-// the query portion will be trimmed
+// the query portion will be trimmed, trailing slash is kept, it's stripped
+// by register for filters and sub-routers, route patterns only differ by it
+// are rejected at server init if ServerOption.StrictSlash is not enabled
 func compile(path string) (newPath string, vars map[string]int) {
 	path = strings2.TrimAfter(path, "?")
-	l := len(path)
-
 	if path[0] != '/' {
 		log.Panicln("Invalid pattern, must start with '/': " + path)
 	}
 
-	if l != 1 && path[l-1] == '/' {
-		path = path[:l-1]
-	}
-
 	sections := strings.Split(path[1:], "/")
 	new := make([]byte, 0, len(path))
 	varIndex := 0
@@ -610,6 +895,16 @@ func compile(path string) (newPath string, vars map[string]int) {
 // every level will be seperated by "-"
 func (rt *router) PrintRouteTree(w io.Writer) {
 	rt.printRouteTree(w, "")
+
+	hosts := make([]string, 0, len(rt.hosts))
+	for host := range rt.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		io.WriteString(w, "host "+host+":\n")
+		rt.hosts[host].printRouteTree(w, "")
+	}
 }
 
 // printRouteTree print route tree with given parent path
@@ -20,8 +20,20 @@ func (gr GroupRouter) Filter(pattern string, f zerver.Filter) error {
 	return gr.Router.Filter(gr.prefix+pattern, f)
 }
 
-func (gr GroupRouter) Handler(pattern string, h zerver.Handler) error {
-	return gr.Router.Handler(gr.prefix+pattern, h)
+func (gr GroupRouter) Handler(pattern string, h zerver.Handler, opts ...zerver.RouteOption) error {
+	return gr.Router.Handler(gr.prefix+pattern, h, opts...)
+}
+
+func (gr GroupRouter) Handle(pattern string, methods []string, fn zerver.HandleFunc, opts ...zerver.RouteOption) error {
+	return gr.Router.Handle(gr.prefix+pattern, methods, fn, opts...)
+}
+
+func (gr GroupRouter) Any(pattern string, fn zerver.HandleFunc, opts ...zerver.RouteOption) error {
+	return gr.Router.Any(gr.prefix+pattern, fn, opts...)
+}
+
+func (gr GroupRouter) Fallback(prefix string, h zerver.Handler) error {
+	return gr.Router.Fallback(gr.prefix+prefix, h)
 }
 
 func (gr GroupRouter) TaskHandler(pattern string, th zerver.TaskHandler) error {
@@ -31,3 +43,13 @@ func (gr GroupRouter) TaskHandler(pattern string, th zerver.TaskHandler) error {
 func (gr GroupRouter) WsHandler(pattern string, th zerver.WsConn) error {
 	return gr.Router.WsHandler(gr.prefix+pattern, th)
 }
+
+// Host return the group of same prefix on router of host
+func (gr GroupRouter) Host(host string) zerver.Router {
+	return NewGroupRouter(gr.Router.Host(host), gr.prefix)
+}
+
+// Route start registration of pattern under the group prefix
+func (gr GroupRouter) Route(pattern string) *zerver.RouteBuilder {
+	return gr.Router.Route(gr.prefix + pattern)
+}
@@ -24,10 +24,23 @@ func (r *HostRouter) AddRouter(host string, rt zerver.Router) {
 	hosts, routers := make([]string, l), make([]zerver.Router, l)
 	copy(hosts, r.hosts)
 	copy(routers, r.routers)
-	hosts[l], routers[l] = host, rt
+	hosts[l-1], routers[l-1] = host, rt
 	r.hosts, r.routers = hosts, routers
 }
 
+// Host return router of host, a new one is added if there is no one for it
+func (r *HostRouter) Host(host string) zerver.Router {
+	for i := range r.hosts {
+		if r.hosts[i] == host {
+			return r.routers[i]
+		}
+	}
+
+	rt := zerver.NewRouter()
+	r.AddRouter(host, rt)
+	return rt
+}
+
 // Implement RouterMatcher
 
 func (r *HostRouter) match(url *url.URL) zerver.Router {
@@ -58,6 +71,18 @@ func (r *HostRouter) Destroy() {
 	return
 }
 
+// Routes return routes of all hosts in the order they are added
+func (r *HostRouter) Routes() []zerver.RouteInfo {
+	var routes []zerver.RouteInfo
+	for i, rt := range r.routers {
+		for _, route := range rt.Routes() {
+			route.Host = r.hosts[i]
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
 func (r *HostRouter) MatchHandlerFilters(url *url.URL) (zerver.Handler, zerver.ReqVars, []zerver.Filter) {
 	if router := r.match(url); router != nil {
 		return router.MatchHandlerFilters(url)
@@ -66,12 +91,12 @@ func (r *HostRouter) MatchHandlerFilters(url *url.URL) (zerver.Handler, zerver.R
 	return nil, zerver.ReqVars{}, nil
 }
 
-func (r *HostRouter) MatchWebSocketHandler(url *url.URL) (zerver.WsHandler, zerver.ReqVars) {
+func (r *HostRouter) MatchWebSocketHandler(url *url.URL) (zerver.WsHandler, zerver.ReqVars, []zerver.Filter) {
 	if router := r.match(url); router != nil {
 		return router.MatchWebSocketHandler(url)
 	}
 
-	return nil, zerver.ReqVars{}
+	return nil, zerver.ReqVars{}, nil
 }
 
 func (r *HostRouter) MatchTaskHandler(url *url.URL) zerver.TaskHandler {
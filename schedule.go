@@ -0,0 +1,206 @@
+package zerver
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cosiner/gohper/errors"
+	log "github.com/cosiner/ygo/jsonlog"
+)
+
+const (
+	ErrInvalidCron = errors.Err("invalid cron spec")
+)
+
+type (
+	// scheduler hold scheduled tasks, all of them is cancelled on server destroy
+	scheduler struct {
+		once sync.Once
+		stop chan struct{}
+	}
+
+	// cronSpec is bit sets of minute, hour, day of month, month, day of week
+	cronSpec struct {
+		minute, hour, dom, month, dow uint64
+		domStar, dowStar              bool
+	}
+)
+
+func (s *scheduler) stopChan() chan struct{} {
+	s.once.Do(func() {
+		s.stop = make(chan struct{})
+	})
+	return s.stop
+}
+
+func (s *scheduler) destroy() {
+	close(s.stopChan())
+}
+
+// ScheduleTask start a task after delay asynchronously, it's cancelled if server
+// is destroyed before that.
+func (s *Server) ScheduleTask(path string, value interface{}, delay time.Duration) error {
+	if s.IsDestroyed() {
+		return ErrServerDestroyed
+	}
+
+	stop := s.sched.stopChan()
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			s.runScheduledTask(path, value)
+		case <-stop:
+		}
+	}()
+	return nil
+}
+
+// ScheduleCron start a task periodically by cron spec asynchronously until
+// server destroyed. The spec is standard 5 fields "minute hour day-of-month
+// month day-of-week", each field support "*", "n", "a-b", "*/n", "a-b/n" and
+// lists of them separated by ",". Runs of same task will not overlap, if a
+// run take too long, the missed schedules are skipped.
+func (s *Server) ScheduleCron(path, spec string, value interface{}) error {
+	if s.IsDestroyed() {
+		return ErrServerDestroyed
+	}
+
+	cron, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	stop := s.sched.stopChan()
+	go func() {
+		for {
+			next := cron.next(time.Now())
+			if next.IsZero() {
+				s.log.Warn(log.M{"msg": "cron spec never match", "pattern": path, "spec": spec})
+				return
+			}
+
+			timer := time.NewTimer(next.Sub(time.Now()))
+			select {
+			case <-timer.C:
+				s.runScheduledTask(path, value)
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Server) runScheduledTask(path string, value interface{}) {
+	if err := s.StartTask(path, value); err != nil && err != ErrServerDestroyed {
+		s.log.Warn(log.M{"msg": "scheduled task failed", "pattern": path, "err": err.Error()})
+	}
+}
+
+func parseCron(spec string) (*cronSpec, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrInvalidCron
+	}
+
+	var (
+		c   cronSpec
+		err error
+	)
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, field := range fields {
+		if *sets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, err
+		}
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidCron
+			}
+			step, part = n, part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			if i := strings.IndexByte(part, '-'); i >= 0 {
+				var err1, err2 error
+				start, err1 = strconv.Atoi(part[:i])
+				end, err2 = strconv.Atoi(part[i+1:])
+				if err1 != nil || err2 != nil {
+					return 0, ErrInvalidCron
+				}
+			} else {
+				n, err := strconv.Atoi(part)
+				if err != nil {
+					return 0, ErrInvalidCron
+				}
+				start = n
+				if step == 1 {
+					end = n
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, ErrInvalidCron
+		}
+
+		for n := start; n <= end; n += step {
+			set |= 1 << uint(n)
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSpec) dayMatch(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch // both restricted, match either as standard cron
+}
+
+// next return the first matched time after t, zero time if there is no match
+// in 5 years
+func (c *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,82 @@
+package zerver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	bits := func(ns ...int) uint64 {
+		var set uint64
+		for _, n := range ns {
+			set |= 1 << uint(n)
+		}
+		return set
+	}
+
+	tests := []struct {
+		field    string
+		min, max int
+		set      uint64
+		err      bool
+	}{
+		{"*", 0, 6, bits(0, 1, 2, 3, 4, 5, 6), false},
+		{"5", 0, 59, bits(5), false},
+		{"1-3", 0, 59, bits(1, 2, 3), false},
+		{"*/20", 0, 59, bits(0, 20, 40), false},
+		{"10-30/10", 0, 59, bits(10, 20, 30), false},
+		{"50/5", 0, 59, bits(50, 55), false},
+		{"1,3,5-6", 0, 6, bits(1, 3, 5, 6), false},
+		{"60", 0, 59, 0, true},
+		{"0", 1, 31, 0, true},
+		{"3-1", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"a", 0, 59, 0, true},
+		{"1-b", 0, 59, 0, true},
+	}
+	for _, tt := range tests {
+		set, err := parseCronField(tt.field, tt.min, tt.max)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: err %v, expect error %t", tt.field, err, tt.err)
+			continue
+		}
+		if set != tt.set {
+			t.Errorf("%q: set %b, expect %b", tt.field, set, tt.set)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-01 is Monday
+	from := time.Date(2024, 1, 1, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// both day fields restricted, either matches
+		{"0 0 15 * 3", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if next := c.next(from); !next.Equal(tt.next) {
+			t.Errorf("%q: next %v, expect %v", tt.spec, next, tt.next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "61 * * * *"} {
+		if _, err := parseCron(spec); err != ErrInvalidCron {
+			t.Errorf("%q: err %v, expect ErrInvalidCron", spec, err)
+		}
+	}
+}
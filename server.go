@@ -1,19 +1,27 @@
 package zerver
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cosiner/gohper/crypto/tls2"
 	"github.com/cosiner/gohper/encoding"
+	"github.com/cosiner/gohper/errors"
 	"github.com/cosiner/gohper/utils/attrs"
 	"github.com/cosiner/gohper/utils/defval"
 	log "github.com/cosiner/ygo/jsonlog"
@@ -22,8 +30,14 @@ import (
 
 const (
 	// server status
-	_NORMAL    = 0
-	_DESTROYED = 1
+	_NORMAL    = 0 // not started
+	_RUNNING   = 1
+	_DESTROYED = 2
+
+	_CONN_SHARDS = 16 // shards of connection counts
+
+	ErrServerDestroyed = errors.Err("server already destroyed")
+	ErrNoCertificate   = errors.Err("there is no listener use this certificate")
 )
 
 type (
@@ -36,12 +50,99 @@ type (
 		// check websocket header, default nil
 		WebSocketChecker HeaderChecker
 
-		// read timeout
+		// read timeout of request include body, if body read by handler timeout
+		// and nothing written, 408 is replied
 		ReadTimeout time.Duration
-		// write timeout
+		// write timeout, connection is closed after that, client will not
+		// receive any status, use route option Timeout to reply 504
 		WriteTimeout time.Duration
-		// max header bytes
+		// max bytes of request line and headers, it's passed to http.Server,
+		// exceeded requests is rejected by standard library with 431, it's
+		// logged with remote address and counted by Server.OversizedHeaders on
+		// plaintext listeners, default 0 means http.DefaultMaxHeaderBytes(1M)
 		MaxHeaderBytes int
+		// max length of request uri, exceeded requests is rejected with 414
+		// before routing, default 0 means unlimited
+		MaxURLLength int
+		// max count of request header lines, exceeded requests is rejected with
+		// 431 before routing, default 0 means unlimited
+		MaxHeaderCount int
+		// max concurrent http requests in service, exceeded requests is
+		// rejected by the overload response, see SetOverloadResponse, websocket
+		// connections are not counted, default 0 means unlimited
+		MaxConcurrentRequests int
+		// called on each connection state change after the internal accounting,
+		// it's called synchronously by net/http, so it must not block
+		ConnStateHook func(net.Conn, http.ConnState)
+		// pause accepting connections once connections in service reach
+		// AcceptHighWater, until they drop to AcceptLowWater, so clients wait
+		// in the listen backlog instead of being accepted to get errors. Idle
+		// keep-alive connections are not counted
+		AcceptBackpressure bool
+		// default 1024
+		AcceptHighWater int
+		// default 3/4 of AcceptHighWater
+		AcceptLowWater int
+		// max bytes of request body, reading beyond it fail and the connection
+		// is closed after response. Request whose Content-Length exceed it is
+		// rejected with 413 before routing, for "Expect: 100-continue" request
+		// "100 Continue" is not sent, so client don't upload the body. Default
+		// 0 means unlimited
+		MaxBodyBytes int64
+		// timeout for each task started by StartTask, TimeoutTaskHandler can
+		// override it, default 0 means no timeout
+		TaskTimeout time.Duration
+		// if enabled, Request.QueryArray also collect values of "name[]",
+		// such as "?tags[]=a&tags[]=b", default only repeated keys is used
+		QueryArrayBrackets bool
+		// each component, handler and filter whose Init take longer than it
+		// will be logged as warning, default 3s, negative means disable
+		SlowInitThreshold time.Duration
+		// by default, Start abort and return the first error of components,
+		// handlers, filters and hooks init, if enabled, errors are only logged
+		// and server start anyway
+		IgnoreInitErrors bool
+		// max time to wait each component Destroy when server destroy, if
+		// exceeded, it's skipped with a warning, 0 means wait forever,
+		// components can override it by implementing DestroyTimeouter
+		ComponentDestroyTimeout time.Duration
+		// destroy components concurrently, components accessed by another one
+		// during initialization are destroyed after it, default sequential
+		ParallelDestroy bool
+		// if enabled, trailing slash of request path is significant, "/foo/" and
+		// "/foo" match different routes, default the slash is stripped and
+		// "/foo/" match "/foo", routes registered with trailing slash is also
+		// matched. Without it, patterns only differ by trailing slash such as
+		// "/foo" and "/foo/" can't be both registered, server init fail with
+		// ErrHandlerExists
+		StrictSlash bool
+		// collapse repeated slashes of request path before routing, "/foo//bar"
+		// match "/foo/bar", then prefix-based filters can't be bypassed by
+		// crafted slashes. It's applied to whole path, so values of catch-all
+		// variables can't contain repeated slashes any more.
+		CleanPath bool
+		// redirect to the cleaned path instead of serving it directly, 301 for
+		// GET and HEAD, 308 for others to keep method and body
+		CleanPathRedirect bool
+		// wrap each listener after tcp keep-alive and tls, such as injecting
+		// faults for testing, the returned listener is closed on Destroy, it
+		// must close the original one
+		ListenerWrapper func(net.Listener) net.Listener
+		// set "X-Content-Type-Options: nosniff" for responses whose default
+		// Content-Type is disabled such as Response.SendFile
+		NoSniff bool
+		// reply HEAD requests by the GET handle func if the route has no HEAD
+		// one, body is discarded by net/http. Routes can opt out by route
+		// option NoAutoMethods
+		AutoHEAD bool
+		// reply OPTIONS requests with 204 and an "Allow" header of route's
+		// methods if the route has no OPTIONS handle func. Routes can opt out
+		// by route option NoAutoMethods
+		AutoOPTIONS bool
+		// disable counting connections of each state, ConnStats and
+		// ActiveConnections always return 0, waiting active connections on
+		// destroy is not affected
+		DisableConnStats bool
 		// tcp keep-alive period by minutes,
 		// default 3 minute, same as predefined in standard http package
 		KeepAlivePeriod time.Duration
@@ -53,9 +154,84 @@ type (
 		// if not nil, cert and key will be ignored
 		TLSConfig *tls.Config
 
+		// listeners with their own tls config, if empty, ListenAddr and
+		// the tls configs above is used as the only listener
+		Listeners []ListenSpec
+
+		// signals make StartAndWait destroy the server, default SIGINT and SIGTERM
+		ShutdownSignals []os.Signal
+		// timeout to wait active connections when destroy server in StartAndWait,
+		// default 0 means wait until all done
+		ShutdownTimeout time.Duration
+
+		// default headers of every response such as "Server", "Content-Type",
+		// they are setted when response init, before filters and handler, so
+		// they can be overridden, see also Response.DisableContentType
 		Headers map[string]string
 		Codec   encoding.Codec
-		Logger  *log.Logger
+		// decode json numbers of Request.Receive into json.Number instead of
+		// float64 for interface{} values, only used with the default json
+		// codec, see also Request.JSONUseNumber
+		JSONUseNumber bool
+		// reply 406 if "Accept" of request doesn't match the default
+		// Content-Type of Headers, default the Content-Type is used anyway.
+		// Routes with Produces option always reply 406 for unacceptable
+		// requests, they are not affected.
+		StrictAccept bool
+		Logger       *log.Logger
+	}
+
+	// ListenSpec is the listening config of an address
+	ListenSpec struct {
+		// listening address
+		Addr string
+		// CA pem files to verify client certs
+		CAs []string
+		// ssl config, default disable tls
+		CertFile, KeyFile string
+		// if not nil, cert and key will be ignored
+		TLSConfig *tls.Config
+	}
+
+	// ConnStats is the count of connections in each state, Hijacked and Closed
+	// is the total count for connections will not be tracked after that
+	ConnStats struct {
+		New      int64
+		Active   int64
+		Idle     int64
+		Hijacked int64
+		Closed   int64
+	}
+
+	// overloadResponse is replied when server is overloaded, it's precomputed
+	// and shared by all requests
+	overloadResponse struct {
+		status int
+		header http.Header
+		body   []byte
+	}
+
+	panicRecord struct {
+		at  time.Time
+		msg string
+	}
+
+	maintenance struct {
+		retryAfter string
+		allow      func(Request) bool
+	}
+
+	connState struct {
+		state   http.ConnState
+		counted bool  // whether it's counted by activeConns
+		shard   uint8 // shard of connCounts
+	}
+
+	// connCounter is a shard of connection counts, padded to a cache line to
+	// avoid false sharing
+	connCounter struct {
+		counts [http.StateClosed + 1]int64
+		_      [64 - (http.StateClosed+1)*8]byte
 	}
 
 	// Server represent a web server
@@ -67,14 +243,59 @@ type (
 
 		checker ws.HandshakeChecker
 
-		listener    net.Listener
+		listeners   []net.Listener
+		certs       []*certificate // certificates loaded from files, can be reloaded
 		state       int32          // destroy or normal running
 		activeConns sync.WaitGroup // connections in service, don't include hijacked and websocket connections
+		connStates  sync.Map       // net.Conn:connState, current state of each connection
+		connCounts  [_CONN_SHARDS]connCounter
+		connShard   uint32 // round-robin shard for new connections
+		connStats   bool
+		connHook    func(net.Conn, http.ConnState)
+		gate        *acceptGate // count active connections if accept backpressure is enabled
+
+		wsUpgradeFailures int64
+		oversizedHeaders  int64
+		panicCount        int64
+		lastPanic         atomic.Value // *panicRecord
+		verboseRoutes     sync.Map     // route pattern:struct{}, routes logged in detail
+		tasks             sync.Map     // task id:*runningTask, in-flight tasks
+		taskID            uint64
 
 		hooks map[string][]LifetimeHook
-
-		headers map[string]string
-		codec   encoding.Codec
+		sched scheduler
+
+		marshalErrHandler func(Response, error)
+		codecSelector     func(Request) (encoding.Codec, bool)
+		respWrapper       func(interface{}) interface{}
+		hostFilters       map[string][]Filter // host:filters, see HostFilter
+		routesInited      bool                // router has been initialized, see CompEnv.Intercept
+		initTracking      bool                // record handlers and filters initialized by config
+		initDepth         int                 // depth of nested initComponent while tracking
+		initialized       []Component         // outermost handlers and filters initialized by config
+		options           ServerOption        // resolved options
+		requestSlots      chan struct{}       // nil if requests is unlimited
+		overload          overloadResponse
+		maintenance       atomic.Value // *maintenance, nil if not in maintenance
+
+		headers     map[string]string
+		codec       encoding.Codec
+		taskTimeout time.Duration
+
+		queryArrayBrackets bool
+		slowInit           time.Duration
+		noSniff            bool
+		maxURLLength       int
+		strictSlash        bool
+		slashRoutes        bool // some routes are registered with trailing slash
+		cleanPath          bool
+		cleanPathRedirect  bool
+		maxHeaderCount     int
+		maxBodyBytes       int64
+		strictAccept       bool
+		jsonUseNumber      bool
+		autoHEAD           bool
+		autoOPTIONS        bool
 
 		log *log.Logger
 	}
@@ -107,6 +328,43 @@ func NewServerWith(rootPath string, rt Router) *Server {
 	}
 }
 
+// SetMarshalErrorHandler set the handler called when Response.Send failed to
+// encode value, nothing of the value has been written at that time. The default
+// handler log the error, and reply 500 with an error body if response is not
+// written, nil restore the default one.
+func (s *Server) SetMarshalErrorHandler(fn func(Response, error)) {
+	s.marshalErrHandler = fn
+}
+
+// SetCodecSelector set a hook to choose codec for each http request, such as
+// protobuf for some clients by header, it's consulted after routing and before
+// filters, request and response use the returned codec for Receive and Send.
+// If it return false, the server codec is used. There is no Accept based
+// negotiation, the selector has the final word.
+func (s *Server) SetCodecSelector(fn func(Request) (encoding.Codec, bool)) {
+	s.codecSelector = fn
+}
+
+// SetResponseWrapper set a function to wrap values sent by Response.Send with
+// successful status, such as a standard envelope {"data": ...}. Routes can
+// opt out by NoResponseWrapper option or Response.DisableResponseWrapper.
+func (s *Server) SetResponseWrapper(fn func(v interface{}) interface{}) {
+	s.respWrapper = fn
+}
+
+func (s *Server) marshalError(resp Response, err error) {
+	if s.marshalErrHandler != nil {
+		s.marshalErrHandler(resp, err)
+		return
+	}
+
+	resp.Logger().Error(log.M{"msg": "marshal response failed", "err": err.Error()})
+	if !resp.Written() {
+		resp.StatusCode(http.StatusInternalServerError)
+		resp.Codec().Encode(resp, NewError(http.StatusText(http.StatusInternalServerError)))
+	}
+}
+
 func (s *Server) Server() *Server {
 	return s
 }
@@ -134,33 +392,209 @@ func (s *Server) Logger() *log.Logger {
 //
 // When global component is initializing, the Environment passed to Init is exactly a
 // CompEnv
-func (s *Server) RegisterComponent(name string, component interface{}) *CompEnv {
+//
+// Components registered before server start will be initialized at start,
+// after that, they will be initialized immediately, and the initial error
+// will be returned, see CompManager for details.
+//
+// If server is destroyed, ErrServerDestroyed will be returned.
+func (s *Server) RegisterComponent(name string, component interface{}) (*CompEnv, error) {
+	if s.IsDestroyed() {
+		return nil, ErrServerDestroyed
+	}
+
 	return s.components.Register(s, name, component)
 }
 
+// SetComponentConfig set config of named component, if the component implements
+// Configurable, it's passed to Configure before Init, it can also be accessed by
+// CompEnv.Config in Init. It should be called before the component initialized.
+func (s *Server) SetComponentConfig(name string, cfg interface{}) {
+	s.components.SetConfig(name, cfg)
+}
+
 func (s *Server) Component(name string) (interface{}, error) {
 	return s.components.Get(name)
 }
 
+// Components return informations of all managed components, for diagnostics
+func (s *Server) Components() []ComponentInfo {
+	return s.components.Infos()
+}
+
 func (s *Server) RemoveComponent(name string) {
 	s.components.Remove(name)
 }
 
-// StartTask start a task synchronously, the value will be passed to task handler
-func (s *Server) StartTask(path string, value interface{}) {
+// StartTask start a task synchronously, the value will be passed to task handler.
+// If server is destroyed, ErrServerDestroyed will be returned.
+//
+// If task timeout is setted, task context will be cancelled on timeout, and
+// StartTask return ErrTaskTimeout without waiting it. If task handler panic,
+// it's recovered and logged, ErrTaskPanic is returned. Task is listed in
+// InFlightTasks until handler returned or it's abandoned, if it's cancelled by
+// CancelTask, ErrTaskCanceled is returned.
+func (s *Server) StartTask(path string, value interface{}) error {
+	if s.IsDestroyed() {
+		return ErrServerDestroyed
+	}
+
 	handler := s.MatchTaskHandler(&url.URL{Path: path})
 	if handler == nil {
 		s.log.Warn(log.M{"msg": "task handler not found", "pattern": path})
-		return
+		return nil
+	}
+
+	timeout := s.taskTimeout
+	if th, is := handler.(timeoutTaskHandler); is {
+		timeout = th.timeout
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	task := s.trackTask(ctx, cancel, path, value)
+	if timeout <= 0 {
+		err := s.runTracked(handler, task)
+		if err == nil && ctx.Err() == context.Canceled {
+			err = ErrTaskCanceled
+		}
+		return err
 	}
 
-	handler.Handle(newTask(path, value))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.runTracked(handler, task)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		s.tasks.Delete(task.ID()) // handler may ignore ctx, don't wait it any more
+		if ctx.Err() == context.Canceled {
+			s.log.Warn(log.M{"msg": "task canceled, abandoned", "pattern": path, "id": task.ID()})
+			return ErrTaskCanceled
+		}
+		s.log.Warn(log.M{"msg": "task timeout, abandoned", "pattern": path, "timeout": timeout.String()})
+		return ErrTaskTimeout
+	}
+}
+
+// trackTask create a task with new id and add it to in-flight tasks
+func (s *Server) trackTask(ctx context.Context, cancel context.CancelFunc, path string, value interface{}) Task {
+	id := strconv.FormatUint(atomic.AddUint64(&s.taskID, 1), 10)
+	s.tasks.Store(id, &runningTask{
+		info: TaskInfo{
+			ID:      id,
+			Pattern: path,
+			Started: time.Now(),
+		},
+		value:  value,
+		cancel: cancel,
+	})
+	return newTask(ctx, id, path, value)
+}
+
+// runTracked run task and remove it from in-flight tasks after handler
+// returned, abandoned tasks are removed earlier by StartTask
+func (s *Server) runTracked(handler TaskHandler, task Task) error {
+	defer s.tasks.Delete(task.ID())
+	return s.runTask(handler, task)
+}
+
+// runTask run task handler, panic of it is recovered and logged, ErrTaskPanic
+// is returned for that
+func (s *Server) runTask(handler TaskHandler, task Task) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			s.log.Error(log.M{"msg": "task panic", "pattern": task.Pattern(), "err": fmt.Sprint(e)})
+			err = ErrTaskPanic
+		}
+	}()
+
+	handler.Handle(task)
+	return nil
+}
+
+// InFlightTasks return tasks whose handler is running and not abandoned by
+// timeout or cancel, sorted by start time
+func (s *Server) InFlightTasks() []TaskInfo {
+	var infos []TaskInfo
+	s.tasks.Range(func(_, v interface{}) bool {
+		t := v.(*runningTask)
+		info := t.info
+		info.Value = valueSummary(t.value)
+		infos = append(infos, info)
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// CancelTask cancel context of an in-flight task, it report whether the task
+// is found. Handler may still run until it notice the cancellation.
+func (s *Server) CancelTask(id string) bool {
+	v, has := s.tasks.Load(id)
+	if has {
+		v.(*runningTask).cancel()
+		s.log.Info(log.M{"msg": "task canceled", "id": id, "pattern": v.(*runningTask).info.Pattern})
+	}
+	return has
+}
+
+// IsRunning report whether server is started and not destroyed
+func (s *Server) IsRunning() bool {
+	return atomic.LoadInt32(&s.state) == _RUNNING
+}
+
+// IsDestroyed report whether server is destroyed
+func (s *Server) IsDestroyed() bool {
+	return atomic.LoadInt32(&s.state) == _DESTROYED
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, request *http.Request) {
-	path := request.URL.Path
-	if l := len(path); l > 1 && path[l-1] == '/' {
-		request.URL.Path = path[:l-1]
+	if s.maxURLLength > 0 && len(request.RequestURI) > s.maxURLLength {
+		w.WriteHeader(http.StatusRequestURITooLong)
+		return
+	}
+	if s.maxHeaderCount > 0 && headerCount(request.Header) > s.maxHeaderCount {
+		s.headerTooLarge(request.RemoteAddr, "MaxHeaderCount")
+		w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+	if s.maxBodyBytes > 0 && request.ContentLength > s.maxBodyBytes {
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if s.maxBodyBytes > 0 && request.Body != nil {
+		request.Body = http.MaxBytesReader(w, request.Body, s.maxBodyBytes)
+	}
+
+	if s.cleanPath {
+		if path, changed := cleanPath(request.URL.Path); changed {
+			request.URL.Path, request.URL.RawPath = path, ""
+			if s.cleanPathRedirect {
+				redirectCleanPath(w, request)
+				return
+			}
+		}
+	}
+	if !s.strictSlash {
+		path := request.URL.Path
+		if l := len(path); l > 1 && path[l-1] == '/' {
+			request.URL.Path = path[:l-1]
+		}
 	}
 
 	if ws.IsWebSocketRequest(request) {
@@ -170,40 +604,391 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, request *http.Request) {
 	}
 }
 
+// headerCount return count of header lines
+func headerCount(headers http.Header) int {
+	var n int
+	for _, vals := range headers {
+		n += len(vals)
+	}
+	return n
+}
+
+// SetRouteLogging mark a route pattern to be logged in detail by log filters,
+// it can be toggled at runtime
+func (s *Server) SetRouteLogging(pattern string, on bool) {
+	if on {
+		s.verboseRoutes.Store(pattern, struct{}{})
+	} else {
+		s.verboseRoutes.Delete(pattern)
+	}
+}
+
+// RouteLogging report whether route pattern is marked by SetRouteLogging
+func (s *Server) RouteLogging(pattern string) bool {
+	_, on := s.verboseRoutes.Load(pattern)
+	return on
+}
+
+// WebSocketUpgradeFailures return the count of failed websocket handshakes
+func (s *Server) WebSocketUpgradeFailures() int64 {
+	return atomic.LoadInt64(&s.wsUpgradeFailures)
+}
+
+// Options return a copy of the options server started with, defaults are
+// applied, key files are redacted and tls configs are removed
+func (s *Server) Options() ServerOption {
+	o := s.options
+	o.KeyFile = redact(o.KeyFile)
+	o.TLSConfig = nil
+	o.Headers = make(map[string]string, len(s.options.Headers))
+	for k, v := range s.options.Headers {
+		o.Headers[k] = v
+	}
+	o.Listeners = make([]ListenSpec, len(s.options.Listeners))
+	for i, l := range s.options.Listeners {
+		l.KeyFile = redact(l.KeyFile)
+		l.TLSConfig = nil
+		o.Listeners[i] = l
+	}
+	return o
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+// SetOverloadResponse set the response replied when server is overloaded such
+// as ServerOption.MaxConcurrentRequests exceeded, default is a bare 503. The
+// response is shared, headers and body must not be modified after that.
+func (s *Server) SetOverloadResponse(status int, headers map[string]string, body []byte) {
+	o := overloadResponse{
+		status: status,
+		header: make(http.Header, len(headers)+1),
+		body:   body,
+	}
+	for k, v := range headers {
+		o.header[http.CanonicalHeaderKey(k)] = []string{v}
+	}
+	if len(body) != 0 {
+		o.header[HEADER_CONTENTLENGTH] = []string{strconv.Itoa(len(body))}
+	}
+	s.overload = o
+}
+
+func (s *Server) overloaded(w http.ResponseWriter) {
+	o := &s.overload
+	if o.status == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	header := w.Header()
+	for k, v := range o.header {
+		header[k] = v
+	}
+	w.WriteHeader(o.status)
+	if len(o.body) != 0 {
+		w.Write(o.body)
+	}
+}
+
+// ReportPanic record a recovered panic of handler, it's called by recovery
+// filters, see PanicCount and LastPanic
+func (s *Server) ReportPanic(err interface{}) {
+	atomic.AddInt64(&s.panicCount, 1)
+	s.lastPanic.Store(&panicRecord{at: time.Now(), msg: fmt.Sprint(err)})
+}
+
+// PanicCount return count of panics reported by ReportPanic
+func (s *Server) PanicCount() int64 {
+	return atomic.LoadInt64(&s.panicCount)
+}
+
+// LastPanic return time and message of the last reported panic, zero time if
+// there is no one
+func (s *Server) LastPanic() (time.Time, string) {
+	if p, _ := s.lastPanic.Load().(*panicRecord); p != nil {
+		return p.at, p.msg
+	}
+	return time.Time{}, ""
+}
+
+// cleanPath collapse repeated slashes of path
+func cleanPath(path string) (string, bool) {
+	if !strings.Contains(path, "//") {
+		return path, false
+	}
+
+	buf := make([]byte, 0, len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		buf = append(buf, path[i])
+	}
+	return string(buf), true
+}
+
+func redirectCleanPath(w http.ResponseWriter, request *http.Request) {
+	loc := request.URL.EscapedPath()
+	if request.URL.RawQuery != "" {
+		loc += "?" + request.URL.RawQuery
+	}
+
+	status := http.StatusPermanentRedirect
+	if request.Method == METHOD_GET || request.Method == METHOD_HEAD {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, request, loc, status)
+}
+
+// retrySlash append a slash to the stripped path if it's not strict, then
+// routes registered with trailing slash can still be matched
+func (s *Server) retrySlash(url *url.URL) bool {
+	if s.strictSlash || !s.slashRoutes || url.Path == "/" {
+		return false
+	}
+	url.Path += "/"
+	return true
+}
+
+// checkSlashRoutes reject routes only differ by trailing slash if it's not
+// strict, requests of both will be served by the one without slash. It also
+// record whether there is any route registered with trailing slash, so
+// retrySlash is skipped if there is no one
+func (s *Server) checkSlashRoutes() error {
+	s.slashRoutes = false
+	if s.strictSlash {
+		return nil
+	}
+
+	routes := make(map[string]string)
+	for _, route := range s.Routes() {
+		if route.Kind == "fallback" {
+			continue
+		}
+		path, _ := compile(route.Pattern)
+		if l := len(path); l > 1 && path[l-1] == '/' {
+			path = path[:l-1]
+			s.slashRoutes = true
+		}
+		key := route.Host + " " + route.Kind + " " + path
+		if pattern, has := routes[key]; has {
+			s.log.Error(log.M{"msg": "routes only differ by trailing slash", "host": route.Host, "pattern": pattern, "conflict": route.Pattern})
+			return ErrHandlerExists
+		}
+		routes[key] = route.Pattern
+	}
+	return nil
+}
+
+// serveWebSocket run filters matched the websocket path during handshake, so
+// authentication, rate-limiting, logging etc. can be shared with http routes,
+// attributes setted by filters can be accessed through WsConn.Attr.
+//
+// If filter don't call the chain, or it has already write response/changed
+// the status code, the connection will not be upgraded.
 func (s *Server) serveWebSocket(w http.ResponseWriter, request *http.Request) {
-	handler, vars := s.MatchWebSocketHandler(request.URL)
+	url := request.URL
+	url.Host = request.Host
+	handler, vars, filters := s.MatchWebSocketHandler(url)
+	if handler == nil && s.retrySlash(url) {
+		if handler, vars, filters = s.MatchWebSocketHandler(url); handler == nil {
+			url.Path = url.Path[:len(url.Path)-1]
+		}
+	}
 	if handler == nil {
 		w.WriteHeader(http.StatusNotFound)
-	} else {
+		return
+	}
+	filters = s.withHostFilters(url.Host, filters)
+
+	reqEnv := newRequestEnv()
+	req := reqEnv.req.init(s, request, &vars)
+	resp := reqEnv.resp.init(s, w)
+
+	serveChain(req, resp, func(req Request, resp Response) {
+		if reqEnv.resp.statusWrited || resp.StatusCode(0) != http.StatusOK {
+			return
+		}
+
 		conn, err := ws.UpgradeWebsocket(w, request, s.checker)
+		reqEnv.resp.upgraded()
 		if err == nil {
-			handler.Handle(newWsConn(s, conn, &vars))
-		} // else connecion will be auto-closed when error occoured,
+			handler.Handle(newWsConn(s, conn, &vars, req))
+		} else { // connecion will be auto-closed when error occoured
+			atomic.AddInt64(&s.wsUpgradeFailures, 1)
+			s.log.Info(log.M{"msg": "websocket upgrade failed", "url": url.String(), "remote": request.RemoteAddr, "err": err.Error()})
+		}
+	}, filters)
+
+	req.destroy()
+	resp.destroy()
+	recycleRequestEnv(reqEnv)
+}
+
+// methodHandler return the handle func of method, explicitly registered ones
+// always win over the automatic HEAD and OPTIONS
+func (s *Server) methodHandler(h Handler, method string) HandleFunc {
+	if fn := h.Handler(method); fn != nil {
+		return fn
+	}
+
+	switch {
+	case method == METHOD_HEAD && s.autoHEAD && routeAutoMethod(h, method):
+		return h.Handler(METHOD_GET)
+	case method == METHOD_OPTIONS && s.autoOPTIONS && routeAutoMethod(h, method):
+		allow := strings.Join(s.allowedMethods(h), ", ")
+		return func(req Request, resp Response) {
+			resp.Headers().Set(HEADER_ALLOW, allow)
+			resp.StatusCode(http.StatusNoContent)
+		}
+	}
+	return nil
+}
+
+// allowedMethods probe the standard methods served by handler, include the
+// automatic ones
+func (s *Server) allowedMethods(h Handler) []string {
+	var methods []string
+	for _, m := range routeMethods {
+		var has bool
+		if m == METHOD_OPTIONS {
+			has = (s.autoOPTIONS && routeAutoMethod(h, m)) || h.Handler(m) != nil
+		} else {
+			has = s.methodHandler(h, m) != nil
+		}
+		if has {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// SetMaintenanceMode make server reply 503 with "Retry-After" for all http
+// requests except those allowed, it's checked before filters, in-flight
+// requests are not affected. If allow is nil, no request is allowed, retryAfter
+// is rounded to seconds and omitted if it's zero.
+func (s *Server) SetMaintenanceMode(on bool, retryAfter time.Duration, allow func(Request) bool) {
+	var m *maintenance
+	if on {
+		m = &maintenance{allow: allow}
+		if secs := int64(retryAfter / time.Second); secs > 0 {
+			m.retryAfter = strconv.FormatInt(secs, 10)
+		}
+	}
+	s.maintenance.Store(m)
+}
+
+// underMaintenance report whether request should be rejected by maintenance
+// mode
+func (s *Server) underMaintenance(req Request, resp Response) bool {
+	m, _ := s.maintenance.Load().(*maintenance)
+	if m == nil || (m.allow != nil && m.allow(req)) {
+		return false
+	}
+
+	if m.retryAfter != "" {
+		resp.Headers().Set(HEADER_RETRYAFTER, m.retryAfter)
 	}
+	resp.StatusCode(http.StatusServiceUnavailable)
+	return true
+}
+
+// acceptable report whether the default Content-Type is acceptable by client,
+// routes with Produces option negotiate by themselves
+func (s *Server) acceptable(h Handler, req Request, resp Response) bool {
+	if routeProduces(h) {
+		return true
+	}
+
+	typ := resp.Headers().Get(HEADER_CONTENTTYPE)
+	return typ == "" || negotiate(req.GetHeader(HEADER_ACCEPT), []string{typ}) != ""
 }
 
 func (s *Server) serveHTTP(w http.ResponseWriter, request *http.Request) {
+	if s.requestSlots != nil {
+		select {
+		case s.requestSlots <- struct{}{}:
+			defer func() { <-s.requestSlots }()
+		default:
+			s.overloaded(w)
+			return
+		}
+	}
+
 	url := request.URL
 	url.Host = request.Host
 	handler, vars, filters := s.MatchHandlerFilters(url)
+	if handler == nil && s.retrySlash(url) {
+		h, v, f := s.MatchHandlerFilters(url)
+		if h != nil {
+			handler, vars, filters = h, v, f
+		} else {
+			url.Path = url.Path[:len(url.Path)-1]
+		}
+	}
+
+	filters = s.withHostFilters(url.Host, filters)
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout := routeTimeout(handler); timeout > 0 {
+		ctx, cancel = context.WithTimeout(request.Context(), timeout)
+		request = request.WithContext(ctx)
+	}
 
 	reqEnv := newRequestEnv()
 	req := reqEnv.req.init(s, request, &vars)
 	resp := reqEnv.resp.init(s, w)
+	if ctx != nil && routeBudget(handler) {
+		reqEnv.resp.budget = ctx
+	}
+	if s.codecSelector != nil {
+		if codec, ok := s.codecSelector(req); ok && codec != nil {
+			reqEnv.req.codec = codec
+			reqEnv.resp.codec = codec
+		}
+	}
 
-	headers := resp.Headers()
-	for k, v := range s.headers {
-		headers.Set(k, v)
+	if handler != nil && isRawOutput(handler) {
+		resp.DisableContentType()
 	}
 
 	var chain FilterChain
-	if handler == nil {
+	if s.underMaintenance(req, resp) {
+		filters = nil
+	} else if handler == nil {
 		resp.StatusCode(http.StatusNotFound)
-	} else if chain = FilterChain(handler.Handler(req.ReqMethod())); chain == nil {
+	} else if chain = FilterChain(s.methodHandler(handler, req.ReqMethod())); chain == nil {
+		resp.Headers().Set(HEADER_ALLOW, strings.Join(s.allowedMethods(handler), ", "))
 		resp.StatusCode(http.StatusMethodNotAllowed)
+	} else if s.strictAccept && !s.acceptable(handler, req, resp) {
+		resp.StatusCode(http.StatusNotAcceptable)
+		chain = nil
 	}
 
-	newFilterChain(chain, filters...)(req, resp)
+	serveChain(req, resp, chain, filters)
+	if !reqEnv.resp.statusWrited {
+		if reqEnv.req.body != nil && reqEnv.req.body.timeout { // client is too slow to send body
+			resp.StatusCode(http.StatusRequestTimeout)
+			resp.Send(NewError("read request body timeout"))
+		} else if cancel != nil && request.Context().Err() == context.DeadlineExceeded {
+			resp.StatusCode(http.StatusGatewayTimeout)
+			resp.Send(NewError("handle request timeout"))
+		}
+	} else if reqEnv.resp.budget != nil && reqEnv.resp.budget.Err() == context.DeadlineExceeded {
+		resp.Flush()
+		s.log.Info(log.M{"msg": "route budget exceeded, response ended", "pattern": vars.pattern, "written": resp.BytesWritten()})
+	}
+	if cancel != nil {
+		cancel()
+	}
 
 	req.destroy()
 	resp.destroy()
@@ -216,79 +1001,217 @@ func (o *ServerOption) init() {
 	}
 
 	defval.String(&o.ListenAddr, ":4000")
+	if len(o.Listeners) == 0 {
+		o.Listeners = []ListenSpec{{
+			Addr:      o.ListenAddr,
+			CAs:       o.CAs,
+			CertFile:  o.CertFile,
+			KeyFile:   o.KeyFile,
+			TLSConfig: o.TLSConfig,
+		}}
+	}
 	if o.KeepAlivePeriod == 0 {
 		o.KeepAlivePeriod = 3 * time.Minute // same as net/http/server.go:tcpKeepAliveListener
 	}
 	if o.Codec == nil {
 		o.Codec = encoding.JSON
 	}
+	if o.SlowInitThreshold == 0 {
+		o.SlowInitThreshold = 3 * time.Second
+	}
+	if len(o.ShutdownSignals) == 0 {
+		o.ShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if o.AcceptBackpressure {
+		if o.AcceptHighWater <= 0 {
+			o.AcceptHighWater = 1024
+		}
+		if o.AcceptLowWater <= 0 || o.AcceptLowWater >= o.AcceptHighWater {
+			o.AcceptLowWater = o.AcceptHighWater * 3 / 4
+		}
+	}
 }
 
 func (o *ServerOption) TLSEnabled() bool {
-	return o.CertFile != "" || o.TLSConfig != nil
+	if o.CertFile != "" || o.TLSConfig != nil {
+		return true
+	}
+
+	for i := range o.Listeners {
+		if o.Listeners[i].TLSEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ListenSpec) TLSEnabled() bool {
+	return l.CertFile != "" || l.TLSConfig != nil
+}
+
+func (o *ServerOption) addrs() []string {
+	addrs := make([]string, len(o.Listeners))
+	for i := range o.Listeners {
+		addrs[i] = o.Listeners[i].Addr
+	}
+	return addrs
 }
 
-func (s *Server) config(o *ServerOption) {
+// tlsEnabled report whether each listener serve tls
+func (o *ServerOption) tlsEnabled() []bool {
+	enabled := make([]bool, len(o.Listeners))
+	for i := range o.Listeners {
+		enabled[i] = o.Listeners[i].TLSEnabled()
+	}
+	return enabled
+}
+
+func (s *Server) config(o *ServerOption) error {
 	o.init()
 
 	var (
-		errors []error
-		logErr = func(err error) {
+		errors  []error
+		initErr = func(err error) bool {
 			if err != nil {
+				if !o.IgnoreInitErrors {
+					return true
+				}
 				errors = append(errors, err)
 			}
+			return false
+		}
+		fail = func(err error) error {
+			s.log.Error(log.M{"msg": "Server start failed.", "err": err.Error()})
+			s.destroyInitialized()
+			return err
 		}
 	)
+	s.options = *o
 	s.log = o.Logger
 	s.codec = o.Codec
 	s.headers = o.Headers
+	s.taskTimeout = o.TaskTimeout
+	s.queryArrayBrackets = o.QueryArrayBrackets
+	s.slowInit = o.SlowInitThreshold
+	s.noSniff = o.NoSniff
+	s.components.destroyTimeout = o.ComponentDestroyTimeout
+	s.components.parallelDestroy = o.ParallelDestroy
+	s.components.log = o.Logger
+	s.maxURLLength = o.MaxURLLength
+	s.strictSlash = o.StrictSlash
+	s.cleanPath = o.CleanPath
+	s.cleanPathRedirect = o.CleanPathRedirect
+	s.maxHeaderCount = o.MaxHeaderCount
+	s.maxBodyBytes = o.MaxBodyBytes
+	s.strictAccept = o.StrictAccept
+	if o.MaxConcurrentRequests > 0 {
+		s.requestSlots = make(chan struct{}, o.MaxConcurrentRequests)
+	}
+	s.jsonUseNumber = o.JSONUseNumber
+	s.autoHEAD = o.AutoHEAD
+	s.autoOPTIONS = o.AutoOPTIONS
+	s.connStats = !o.DisableConnStats
+	s.connHook = o.ConnStateHook
 	s.checker = ws.HeaderChecker(o.WebSocketChecker).HandshakeCheck
 
-	logErr(s.components.Init(s))
+	s.initTracking = true
+	defer func() {
+		s.initTracking = false
+		s.initialized = nil
+	}()
+	if err := s.components.Init(s); initErr(err) {
+		return fail(err)
+	}
 
-	s.log.Info(log.M{"msg": "Execute registered init before routes funcs "})
+	s.log.Debug(log.M{"msg": "Execute registered init before routes funcs "})
 	for _, f := range s.OnLoadRoutes() {
-		logErr(f(s))
+		if err := f(s); initErr(err) {
+			return fail(err)
+		}
 	}
 
-	s.log.Info(log.M{"msg": "Init Handlers and Filters"})
-	logErr(s.Router.Init(s))
+	s.log.Debug(log.M{"msg": "Init Handlers and Filters"})
+	if err := s.Router.Init(s); initErr(err) {
+		return fail(err)
+	}
+	s.routesInited = true
+	if err := s.checkSlashRoutes(); initErr(err) {
+		return fail(err)
+	}
+	if err := s.initHostFilters(); initErr(err) {
+		return fail(err)
+	}
 
-	s.log.Info(log.M{"msg": "Execute registered finial init funcs"})
+	s.log.Debug(log.M{"msg": "Execute registered finial init funcs"})
 	for _, f := range s.OnStart() {
-		logErr(f(s))
+		if err := f(s); initErr(err) {
+			return fail(err)
+		}
 	}
 
 	if len(errors) != 0 {
-		s.log.Fatal(log.M{"msg": "Server start failed.", "error": errors})
+		s.log.Error(log.M{"msg": "Server init failed, ignored.", "error": errors})
 	}
-	s.log.Info(log.M{"msg": "server start", "addr": o.ListenAddr})
+	s.log.Info(log.M{
+		"msg":              "server start",
+		"addr":             o.addrs(),
+		"tls":              o.tlsEnabled(),
+		"routes":           len(s.Routes()),
+		"components":       len(s.components.Infos()),
+		"content_type":     o.Headers[HEADER_CONTENTTYPE],
+		"read_timeout":     o.ReadTimeout.String(),
+		"write_timeout":    o.WriteTimeout.String(),
+		"task_timeout":     o.TaskTimeout.String(),
+		"shutdown_timeout": o.ShutdownTimeout.String(),
+	})
 	runtime.GC()
+	return nil
 }
 
-// Start server as http server, if opt is nil, use default configurations
+// Start server as http server, if opt is nil, use default configurations.
+// It block until server stop, if it's stopped by Destroy, nil is returned,
+// otherwise the init, listen or serve error is returned. If any listener fail
+// to serve, the server is destroyed with ServerOption.ShutdownTimeout.
 func (s *Server) Start(opt *ServerOption) error {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	if opt == nil {
 		opt = &ServerOption{}
 	}
-	s.config(opt)
+	if err := s.config(opt); err != nil {
+		return err
+	}
 
-	l, err := s.listen(opt)
+	ls, err := s.listen(opt)
 	if err != nil {
 		return err
 	}
 
-	s.listener = l
+	s.listeners = ls
+	atomic.StoreInt32(&s.state, _RUNNING)
 	srv := &http.Server{
-		ReadTimeout:  opt.ReadTimeout,
-		WriteTimeout: opt.WriteTimeout,
-		Handler:      s,
-		ConnState:    s.connStateHook,
+		ReadTimeout:    opt.ReadTimeout,
+		WriteTimeout:   opt.WriteTimeout,
+		MaxHeaderBytes: opt.MaxHeaderBytes,
+		Handler:        s,
+		ConnState:      s.connStateHook,
 	}
 
-	return srv.Serve(l)
+	errs := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) {
+			errs <- srv.Serve(l)
+		}(l)
+	}
+
+	err = <-errs
+	if err == http.ErrServerClosed || s.IsDestroyed() { // closed listener error after destroy
+		return nil
+	}
+	// other listeners are still serving, stop all of them and release resources
+	s.log.Error(log.M{"msg": "serve failed, destroying server", "err": err.Error()})
+	s.Destroy(opt.ShutdownTimeout)
+	return err
 }
 
 // from net/http/server/go
@@ -305,103 +1228,277 @@ func (ln *tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 
 	// if keep-alive fail, don't care
 	_ = tc.SetKeepAlive(true)
-	_ = tc.SetKeepAlivePeriod(time.Duration(ln.AlivePeriod) * time.Minute)
+	_ = tc.SetKeepAlivePeriod(ln.AlivePeriod)
 
 	return tc, nil
 }
 
-func (s *Server) listen(opt *ServerOption) (net.Listener, error) {
-	ln, err := net.Listen("tcp", opt.ListenAddr)
+func (s *Server) listen(opt *ServerOption) ([]net.Listener, error) {
+	var gate func(net.Listener) net.Listener
+	if opt.AcceptBackpressure {
+		s.gate = newAcceptGate(opt.AcceptHighWater, opt.AcceptLowWater)
+		gate = s.gate.wrap
+	}
+
+	ls := make([]net.Listener, 0, len(opt.Listeners))
+	for i := range opt.Listeners {
+		wrap := gate
+		if !opt.Listeners[i].TLSEnabled() {
+			wrap = func(l net.Listener) net.Listener {
+				if gate != nil {
+					l = gate(l)
+				}
+				return headerLimitListener{Listener: l, s: s}
+			}
+		}
+		l, cert, err := opt.Listeners[i].listen(opt.KeepAlivePeriod, wrap)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			s.certs = nil
+			return nil, err
+		}
+		if opt.ListenerWrapper != nil {
+			l = opt.ListenerWrapper(l)
+		}
+		ls = append(ls, l)
+		if cert != nil {
+			s.certs = append(s.certs, cert)
+		}
+	}
+	return ls, nil
+}
+
+// listen create listener, wrap is applied before tls if it's not nil
+func (l *ListenSpec) listen(keepAlive time.Duration, wrap func(net.Listener) net.Listener) (net.Listener, *certificate, error) {
+	ln, err := net.Listen("tcp", l.Addr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	ln = &tcpKeepAliveListener{
 		TCPListener: ln.(*net.TCPListener),
-		AlivePeriod: opt.KeepAlivePeriod,
+		AlivePeriod: keepAlive,
+	}
+	if wrap != nil {
+		ln = wrap(ln)
 	}
 
-	if opt.TLSConfig != nil {
-		return tls.NewListener(ln, opt.TLSConfig), nil
+	tc, cert, err := l.tlsConfig()
+	if err != nil {
+		ln.Close()
+		return nil, nil, err
 	}
-	if opt.CertFile == "" {
-		return ln, nil
+	if tc != nil {
+		ln = tls.NewListener(ln, tc)
+	}
+	return ln, cert, nil
+}
+
+func (l *ListenSpec) tlsConfig() (*tls.Config, *certificate, error) {
+	if l.TLSConfig != nil {
+		return l.TLSConfig, nil, nil
+	}
+	if l.CertFile == "" {
+		return nil, nil, nil
 	}
 
 	// from net/http/server.go.ListenAndServeTLS
 	tc := &tls.Config{
-		NextProtos:   []string{"http/1.1"},
-		Certificates: make([]tls.Certificate, 1),
+		NextProtos: []string{"http/1.1"},
+	}
+
+	cert := &certificate{certFile: l.CertFile}
+	err := cert.load(l.CertFile, l.KeyFile)
+	if err == nil && l.CAs != nil {
+		tc.ClientCAs, err = tls2.CAPool(l.CAs...)
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
 	}
+	tc.GetCertificate = cert.get
+	return tc, cert, err
+}
+
+// certificate is a hot-swappable tls certificate loaded from files
+type certificate struct {
+	certFile string
+	cert     atomic.Value // *tls.Certificate
+}
 
-	tc.Certificates[0], err = tls.LoadX509KeyPair(opt.CertFile, opt.KeyFile)
+func (c *certificate) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err == nil {
-		if opt.CAs != nil {
-			tc.ClientCAs, err = tls2.CAPool(opt.CAs...)
-			if err == nil {
-				tc.ClientAuth = tls.RequireAndVerifyClientCert
+		c.cert.Store(&cert)
+	}
+	return err
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load().(*tls.Certificate), nil
+}
+
+// ReloadCertificate reload the certificate for listeners whose certificate is
+// loaded from certFile, it's designed for certificate rotation that the cert/key
+// files are replaced in place. New connections will use the new certificate,
+// existing connections are unaffected.
+//
+// If there is no such listener, ErrNoCertificate is returned, listeners use
+// custom TLSConfig should manage certificates itself.
+func (s *Server) ReloadCertificate(certFile, keyFile string) error {
+	var found bool
+	for _, c := range s.certs {
+		if c.certFile == certFile {
+			if err := c.load(certFile, keyFile); err != nil {
+				return err
 			}
-		}
-		if err == nil {
-			ln = tls.NewListener(ln, tc)
+			found = true
 		}
 	}
-	if err != nil {
-		ln.Close()
+
+	if !found {
+		return ErrNoCertificate
+	}
+	return nil
+}
+
+// ConnStats return count of connections in each state
+func (s *Server) ConnStats() ConnStats {
+	return ConnStats{
+		New:      s.connCount(http.StateNew),
+		Active:   s.connCount(http.StateActive),
+		Idle:     s.connCount(http.StateIdle),
+		Hijacked: s.connCount(http.StateHijacked),
+		Closed:   s.connCount(http.StateClosed),
+	}
+}
+
+// ActiveConnections return count of connections which is processing request
+func (s *Server) ActiveConnections() int64 {
+	return s.connCount(http.StateActive)
+}
+
+// connCount sum the count of state in all shards, the result is not an atomic
+// snapshot, it may be a little off under heavy load
+func (s *Server) connCount(state http.ConnState) int64 {
+	var n int64
+	for i := range s.connCounts {
+		n += atomic.LoadInt64(&s.connCounts[i].counts[state])
 	}
-	return nil, err
+	return n
 }
 
+// connStateHook track connection states, and make activeConns count
+// connections in service. A connection hold a slot of activeConns and the
+// accept gate when it become active, and release it when it leave the active
+// state, whatever it become idle, hijacked or closed.
+//
+// Counts of states are sharded by connection to reduce contention, they can be
+// disabled by ServerOption.DisableConnStats.
 func (s *Server) connStateHook(conn net.Conn, state http.ConnState) {
+	var (
+		counted bool
+		shard   uint8
+	)
+	if prev, has := s.connStates.Load(conn); has {
+		p := prev.(connState)
+		counted, shard = p.counted, p.shard
+		if s.connStats {
+			atomic.AddInt64(&s.connCounts[shard].counts[p.state], -1)
+		}
+	} else if s.connStats {
+		shard = uint8(atomic.AddUint32(&s.connShard, 1) % _CONN_SHARDS)
+	}
+
+	if counted && state != http.StateActive {
+		s.activeConns.Done()
+		if s.gate != nil {
+			s.gate.release()
+		}
+		counted = false
+	}
 	switch state {
 	case http.StateActive:
-		if atomic.LoadInt32(&s.state) == _NORMAL {
+		if counted {
+			break
+		}
+		if !s.IsDestroyed() {
 			s.activeConns.Add(1)
+			if s.gate != nil {
+				s.gate.acquire()
+			}
+			counted = true
 		} else {
 			// previous idle connections before call server.Destroy() becomes active, directly close it
 			conn.Close()
 		}
 	case http.StateIdle:
-		if atomic.LoadInt32(&s.state) == _DESTROYED {
+		if s.IsDestroyed() {
 			conn.Close()
 		}
-		s.activeConns.Done()
-	case http.StateHijacked:
-		s.activeConns.Done()
+	}
+
+	if state == http.StateHijacked || state == http.StateClosed {
+		s.connStates.Delete(conn)
+	} else {
+		s.connStates.Store(conn, connState{state: state, counted: counted, shard: shard})
+	}
+	if s.connStats {
+		atomic.AddInt64(&s.connCounts[shard].counts[state], 1)
+	}
+	if s.connHook != nil {
+		s.connHook(conn, state)
 	}
 }
 
 // Destroy server, release all resources, if destroyed, server can't be reused
 // It only wait for managed connections, hijacked/websocket connections will not waiting
-// if timeout or server already destroyed, false was returned
+// if timeout, server not started or already destroyed, false was returned
+//
+// The destroy order is guaranteed: handlers and filters(Router) first, then
+// components, finally the OnDestroy hooks, so components always outlive the
+// filters and handlers depend on them. Before all of these, Drainable handlers
+// are notified and waited while listeners still accept connections.
 func (s *Server) Destroy(timeout time.Duration) bool {
-	if !atomic.CompareAndSwapInt32(&s.state, _NORMAL, _DESTROYED) { // signal close idle connections
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return s.DestroyContext(ctx)
+}
+
+// DestroyContext is same as Destroy, but wait active connections until
+// ctx is done instead of a timeout.
+func (s *Server) DestroyContext(ctx context.Context) bool {
+	if !atomic.CompareAndSwapInt32(&s.state, _RUNNING, _DESTROYED) { // signal close idle connections
 		return false
 	}
 
-	var isTimeout = true
-	err := s.listener.Close() // don't accept connections
-	if err != nil {
-		s.log.Warn(log.M{"msg": "server listener close failed", "err": err.Error()})
+	s.drain(ctx)
+	for _, l := range s.listeners { // don't accept connections
+		if err := l.Close(); err != nil {
+			s.log.Warn(log.M{"msg": "server listener close failed", "addr": l.Addr().String(), "err": err.Error()})
+		}
 	}
 
-	if timeout > 0 {
-		c := make(chan struct{})
-		go func(s *Server, c chan struct{}) {
-			s.activeConns.Wait() // wait connections in service to be idle
-			close(c)
-		}(s, c)
+	s.sched.destroy() // cancel scheduled tasks
 
-		select {
-		case <-time.NewTicker(timeout).C:
-		case <-c:
-			isTimeout = false
-		}
-	} else {
+	var isTimeout bool
+	c := make(chan struct{})
+	go func(s *Server, c chan struct{}) {
 		s.activeConns.Wait() // wait connections in service to be idle
+		close(c)
+	}(s, c)
+
+	select {
+	case <-ctx.Done():
+		isTimeout = true
+	case <-c:
 	}
 
 	s.Router.Destroy()
+	s.destroyHostFilters()
 	s.components.Destroy()
 
 	for _, fn := range s.OnDestroy() {
@@ -414,6 +1511,80 @@ func (s *Server) Destroy(timeout time.Duration) bool {
 	return !isTimeout
 }
 
+// destroyInitialized destroy handlers, filters and components initialized by
+// config in reverse order when server init failed, those not reached or
+// failed are not destroyed
+func (s *Server) destroyInitialized() {
+	for i := len(s.initialized) - 1; i >= 0; i-- {
+		s.initialized[i].Destroy()
+	}
+	s.initialized = nil
+	s.components.Destroy()
+}
+
+// drain call all Drainable handlers concurrently, return when all of them
+// returned or ctx is done
+func (s *Server) drain(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, route := range s.Routes() {
+		if d, is := route.Handler.(Drainable); is {
+			wg.Add(1)
+			go func(d Drainable) {
+				defer wg.Done()
+				d.Drain(ctx)
+			}(d)
+		}
+	}
+
+	c := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(c)
+	}()
+	select {
+	case <-ctx.Done():
+		s.log.Warn(log.M{"msg": "drain handlers timeout"})
+	case <-c:
+	}
+}
+
+// StartAndWait start server and block until one of ServerOption.ShutdownSignals
+// received, then destroy the server with ServerOption.ShutdownTimeout. If server
+// start failed, the error is returned directly.
+func (s *Server) StartAndWait(opt *ServerOption) error {
+	if opt == nil {
+		opt = &ServerOption{}
+	}
+	opt.init()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, opt.ShutdownSignals...)
+	defer signal.Stop(sigs)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Start(opt)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-sigs:
+		opt.Logger.Info(log.M{"msg": "received shutdown signal, destroying server", "signal": sig.String()})
+	}
+
+	ctx := context.Background()
+	if opt.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.ShutdownTimeout)
+		defer cancel()
+	}
+	if !s.DestroyContext(ctx) {
+		opt.Logger.Warn(log.M{"msg": "server destroy timeout or not running"})
+	}
+	return nil
+}
+
 func (s *Server) appendHooks(typ string, fn ...LifetimeHook) []LifetimeHook {
 	hooks := s.hooks[typ]
 	hooks = append(hooks, fn...)
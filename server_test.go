@@ -0,0 +1,570 @@
+package zerver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cosiner/gohper/encoding"
+)
+
+// newTestServer configure a server with routes setted by fn, it's not listening,
+// requests are served by ServeHTTP directly
+func newTestServer(t testing.TB, opt *ServerOption, fn func(Router)) *Server {
+	s := NewServer("")
+	if fn != nil {
+		fn(s.Router)
+	}
+	if opt == nil {
+		opt = &ServerOption{}
+	}
+	if err := s.config(opt); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func serve(s *Server, method, url string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, body)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// prefixCodec is json with a prefix before each document
+type prefixCodec struct {
+	encoding.Codec
+	prefix string
+}
+
+func (c prefixCodec) Encode(w io.Writer, v interface{}) error {
+	io.WriteString(w, c.prefix)
+	return c.Codec.Encode(w, v)
+}
+
+func (c prefixCodec) Decode(r io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(c.prefix)) {
+		return io.ErrUnexpectedEOF
+	}
+	return c.Codec.Decode(bytes.NewReader(data[len(c.prefix):]), v)
+}
+
+func TestCodecSelector(t *testing.T) {
+	codec := prefixCodec{Codec: encoding.JSON, prefix: "prefix:"}
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Handle("/echo", []string{METHOD_POST}, func(req Request, resp Response) {
+			var v map[string]string
+			if err := req.Receive(&v); err != nil {
+				resp.StatusCode(http.StatusBadRequest)
+				return
+			}
+			resp.Send(v)
+		})
+	})
+	s.SetCodecSelector(func(req Request) (encoding.Codec, bool) {
+		return codec, req.GetHeader("X-Codec") == "prefix"
+	})
+
+	tests := []struct {
+		codec  string
+		body   string
+		status int
+		resp   string
+	}{
+		{"prefix", `prefix:{"a":"b"}`, http.StatusOK, `prefix:{"a":"b"}`},
+		{"prefix", `{"a":"b"}`, http.StatusBadRequest, ``},
+		{"", `{"a":"b"}`, http.StatusOK, `{"a":"b"}`},
+	}
+	for i, tt := range tests {
+		w := serve(s, METHOD_POST, "/echo", strings.NewReader(tt.body), http.Header{"X-Codec": {tt.codec}})
+		if w.Code != tt.status {
+			t.Errorf("%d: status %d, expect %d", i, w.Code, tt.status)
+		}
+		if got := strings.TrimSpace(w.Body.String()); got != tt.resp {
+			t.Errorf("%d: body %q, expect %q", i, got, tt.resp)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	var writeErr error
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.Handle("/stream", []string{METHOD_GET}, func(req Request, resp Response) {
+			resp.Write([]byte("part"))
+			resp.Flush()
+			<-req.Context().Done()
+			_, writeErr = resp.Write([]byte("late"))
+		}, Budget(20*time.Millisecond))
+	})
+
+	w := serve(s, METHOD_GET, "/stream", nil, nil)
+	if writeErr != ErrBudgetExceeded {
+		t.Fatalf("write after budget: %v, expect ErrBudgetExceeded", writeErr)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "part" {
+		t.Fatalf("response %d %q, expect 200 \"part\"", w.Code, w.Body.String())
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		clean   string
+		changed bool
+	}{
+		{"/", "/", false},
+		{"/a/b", "/a/b", false},
+		{"//", "/", true},
+		{"/a//b", "/a/b", true},
+		{"//a///b//", "/a/b/", true},
+	}
+	for _, tt := range tests {
+		if clean, changed := cleanPath(tt.path); clean != tt.clean || changed != tt.changed {
+			t.Errorf("%q: got %q %t, expect %q %t", tt.path, clean, changed, tt.clean, tt.changed)
+		}
+	}
+}
+
+func TestCleanPathServe(t *testing.T) {
+	routes := func(rt Router) {
+		rt.Handle("/admin/users", []string{METHOD_GET, METHOD_POST}, func(req Request, resp Response) {
+			resp.Write([]byte(req.URL().Path))
+		})
+	}
+	tests := []struct {
+		opt      ServerOption
+		method   string
+		url      string
+		status   int
+		location string
+	}{
+		{ServerOption{}, METHOD_GET, "//admin//users", http.StatusNotFound, ""},
+		{ServerOption{CleanPath: true}, METHOD_GET, "//admin//users", http.StatusOK, ""},
+		{ServerOption{CleanPath: true, CleanPathRedirect: true}, METHOD_GET, "/admin//users?a=1", http.StatusMovedPermanently, "/admin/users?a=1"},
+		{ServerOption{CleanPath: true, CleanPathRedirect: true}, METHOD_POST, "/admin//users", http.StatusPermanentRedirect, "/admin/users"},
+		{ServerOption{CleanPath: true, CleanPathRedirect: true}, METHOD_GET, "/admin/users", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		opt := tt.opt
+		s := newTestServer(t, &opt, routes)
+		w := serve(s, tt.method, tt.url, nil, nil)
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%+v %s %s: got %d %q, expect %d %q", tt.opt, tt.method, tt.url, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+// serveConns serve server on a local listener with the same http.Server
+// config as Start, it return the address and a function to stop serving
+func serveConns(t *testing.T, s *Server) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:   s,
+		ConnState: s.connStateHook,
+	}
+	go srv.Serve(l)
+	return l.Addr().String(), func() { srv.Close() }
+}
+
+// waitConns wait until connection counts match expectation
+func waitConns(t *testing.T, s *Server, expect ConnStats) {
+	deadline := time.Now().Add(2 * time.Second)
+	for s.ConnStats() != expect {
+		if time.Now().After(deadline) {
+			t.Fatalf("conn stats %+v, expect %+v", s.ConnStats(), expect)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitActiveConns wait until activeConns is released by all connections
+func waitActiveConns(t *testing.T, s *Server) {
+	done := make(chan struct{})
+	go func() {
+		s.activeConns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("activeConns is not released")
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		active []int64
+		s      *Server
+	)
+	s = newTestServer(t, nil, func(rt Router) {
+		rt.Handle("/echo", []string{METHOD_POST}, func(req Request, resp Response) {
+			mu.Lock()
+			active = append(active, s.ActiveConnections())
+			mu.Unlock()
+
+			if req.Attr("seen") != nil {
+				resp.StatusCode(http.StatusInternalServerError) // attrs leaked from previous request
+				return
+			}
+			req.SetAttr("seen", true)
+			if resp.Headers().Get("X-Id") != "" {
+				resp.StatusCode(http.StatusInternalServerError) // headers leaked
+				return
+			}
+			resp.Headers().Set("X-Id", req.Vars().QueryVar("id"))
+
+			body, _ := ioutil.ReadAll(req)
+			resp.Write([]byte(req.Vars().QueryVar("id") + ":" + string(body)))
+		})
+	})
+	addr, stop := serveConns(t, s)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5
+	var pipeline bytes.Buffer
+	for i := 0; i < n; i++ {
+		body := strings.Repeat(fmt.Sprint(i), i+1)
+		fmt.Fprintf(&pipeline, "POST /echo?id=%d HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\n\r\n%s", i, len(body), body)
+	}
+	if _, err := conn.Write(pipeline.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	for i := 0; i < n; i++ {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		expect := fmt.Sprintf("%d:%s", i, strings.Repeat(fmt.Sprint(i), i+1))
+		if resp.StatusCode != http.StatusOK || string(body) != expect || resp.Header.Get("X-Id") != fmt.Sprint(i) {
+			t.Errorf("%d: got %d %q %q, expect 200 %q", i, resp.StatusCode, resp.Header.Get("X-Id"), body, expect)
+		}
+	}
+
+	waitConns(t, s, ConnStats{Idle: 1})
+	for i, n := range active {
+		if n != 1 {
+			t.Errorf("request %d: active connections %d, expect 1", i, n)
+		}
+	}
+
+	conn.Close()
+	waitConns(t, s, ConnStats{Closed: 1})
+	waitActiveConns(t, s)
+}
+
+func TestConnStateHook(t *testing.T) {
+	var (
+		hookMu sync.Mutex
+		hooked []http.ConnState
+	)
+	opt := &ServerOption{
+		ConnStateHook: func(_ net.Conn, state http.ConnState) {
+			hookMu.Lock()
+			hooked = append(hooked, state)
+			hookMu.Unlock()
+		},
+	}
+	release := make(chan struct{})
+	s := newTestServer(t, opt, func(rt Router) {
+		rt.Handle("/slow", []string{METHOD_GET}, func(req Request, resp Response) {
+			<-release
+		})
+		rt.Handle("/hijack", []string{METHOD_GET}, func(req Request, resp Response) {
+			conn, _, err := resp.Hijack()
+			if err == nil {
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+				conn.Close()
+			}
+		})
+	})
+	addr, stop := serveConns(t, s)
+	defer stop()
+
+	get := func(path string) *http.Response {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\n\r\n", path)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("/slow")
+		}()
+	}
+	waitConns(t, s, ConnStats{Active: 3})
+	close(release)
+	wg.Wait()
+	waitConns(t, s, ConnStats{Closed: 3})
+	waitActiveConns(t, s)
+
+	get("/hijack")
+	waitConns(t, s, ConnStats{Hijacked: 1, Closed: 3})
+	waitActiveConns(t, s)
+
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	counts := make(map[http.ConnState]int)
+	for _, state := range hooked {
+		counts[state]++
+	}
+	if counts[http.StateNew] != 4 || counts[http.StateActive] != 4 ||
+		counts[http.StateClosed] != 3 || counts[http.StateHijacked] != 1 {
+		t.Errorf("hooked states %v", counts)
+	}
+}
+
+// fakeConn is a distinct connection key for connStateHook
+type fakeConn struct {
+	net.Conn
+	id int
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func BenchmarkConnStateHook(b *testing.B) {
+	lifetime := []http.ConnState{
+		http.StateNew, http.StateActive, http.StateIdle,
+		http.StateActive, http.StateIdle, http.StateClosed,
+	}
+
+	for _, bb := range []struct {
+		name string
+		opt  ServerOption
+	}{
+		{"Sharded", ServerOption{}},
+		{"DisableConnStats", ServerOption{DisableConnStats: true}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			opt := bb.opt
+			s := newTestServer(b, &opt, nil)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				conn := &fakeConn{}
+				for pb.Next() {
+					for _, state := range lifetime {
+						s.connStateHook(conn, state)
+					}
+				}
+			})
+		})
+	}
+}
+
+// failListener fail to accept, so the http server serving it stop
+type failListener struct {
+	net.Listener
+}
+
+func (l failListener) Accept() (net.Conn, error) {
+	return nil, fmt.Errorf("accept failed")
+}
+
+func TestStartServeFailure(t *testing.T) {
+	var n int
+	s := NewServer("")
+	err := s.Start(&ServerOption{
+		Listeners: []ListenSpec{{Addr: "127.0.0.1:0"}, {Addr: "127.0.0.1:0"}},
+		ListenerWrapper: func(l net.Listener) net.Listener {
+			if n++; n == 1 {
+				return failListener{Listener: l}
+			}
+			return l
+		},
+	})
+	if err == nil {
+		t.Fatal("serve error is not returned")
+	}
+	if s.IsRunning() {
+		t.Fatal("server still running")
+	}
+	if c, err := net.Dial("tcp", s.listeners[1].Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("healthy listener is not closed")
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	handle := func(rt Router, pattern string) {
+		rt.Handle(pattern, []string{METHOD_GET}, func(req Request, resp Response) {
+			io.WriteString(resp, pattern)
+		})
+	}
+
+	s := NewServer("")
+	handle(s.Router, "/foo")
+	handle(s.Router, "/foo/")
+	if err := s.config(&ServerOption{}); err != ErrHandlerExists {
+		t.Fatalf("routes only differ by trailing slash: want ErrHandlerExists, got %v", err)
+	}
+
+	tests := []struct {
+		strict   bool
+		patterns []string
+		path     string
+		code     int
+		body     string
+	}{
+		{false, []string{"/foo"}, "/foo/", http.StatusOK, "/foo"},
+		{false, []string{"/foo/"}, "/foo", http.StatusOK, "/foo/"},
+		{false, []string{"/foo/"}, "/foo/", http.StatusOK, "/foo/"},
+		{false, []string{"/foo"}, "/bar/", http.StatusNotFound, ""},
+		{true, []string{"/foo", "/foo/"}, "/foo", http.StatusOK, "/foo"},
+		{true, []string{"/foo", "/foo/"}, "/foo/", http.StatusOK, "/foo/"},
+		{true, []string{"/foo"}, "/foo/", http.StatusNotFound, ""},
+	}
+	for i, test := range tests {
+		s := newTestServer(t, &ServerOption{StrictSlash: test.strict}, func(rt Router) {
+			for _, p := range test.patterns {
+				handle(rt, p)
+			}
+		})
+		w := serve(s, METHOD_GET, test.path, nil, nil)
+		if w.Code != test.code || (test.code == http.StatusOK && w.Body.String() != test.body) {
+			t.Errorf("%d: want %d %q, got %d %q", i, test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+// initFilter record whether it's destroyed, Init fail if err is not nil
+type initFilter struct {
+	err       error
+	destroyed bool
+}
+
+func (f *initFilter) Init(Env) error { return f.err }
+func (f *initFilter) Destroy()       { f.destroyed = true }
+func (f *initFilter) Filter(req Request, resp Response, chain FilterChain) {
+	chain(req, resp)
+}
+
+func TestConfigFailDestroy(t *testing.T) {
+	var logs []string
+	ok, bad, unreached := &initFilter{}, &initFilter{err: fmt.Errorf("init failed")}, &initFilter{}
+	s := NewServer("")
+	s.RegisterComponent("db", &orderComp{name: "db", log: &logs})
+	s.Filter("/a", ok)
+	s.Filter("/b", bad)
+	s.Filter("/c", unreached)
+	if err := s.config(&ServerOption{}); err != bad.err {
+		t.Fatalf("want init error, got %v", err)
+	}
+
+	if !ok.destroyed || bad.destroyed || unreached.destroyed {
+		t.Errorf("destroyed: initialized %t, failed %t, unreached %t", ok.destroyed, bad.destroyed, unreached.destroyed)
+	}
+	if expect := []string{"init db", "destroy db"}; !reflect.DeepEqual(logs, expect) {
+		t.Errorf("component: want %v, got %v", expect, logs)
+	}
+}
+
+func TestHandlerInvoked(t *testing.T) {
+	var invoked bool
+	record := FilterFunc(func(req Request, resp Response, chain FilterChain) {
+		if req.GetHeader("X-Abort") == "" {
+			chain(req, resp)
+		}
+		invoked = req.HandlerInvoked()
+	})
+	inHandler := func(req Request, resp Response) {
+		invoked = req.HandlerInvoked()
+	}
+	tests := []struct {
+		filter bool
+		method string
+		path   string
+		abort  string
+		code   int
+		expect bool
+	}{
+		{false, METHOD_GET, "/a", "", http.StatusOK, true},
+		{true, METHOD_GET, "/a", "", http.StatusOK, true},
+		{true, METHOD_GET, "/a", "1", http.StatusOK, false},
+		{true, METHOD_GET, "/none", "", http.StatusNotFound, false},
+		{true, METHOD_POST, "/a", "", http.StatusMethodNotAllowed, false},
+	}
+	for i, tt := range tests {
+		s := newTestServer(t, nil, func(rt Router) {
+			rt.Handle("/a", []string{METHOD_GET}, inHandler)
+			if tt.filter {
+				rt.Filter("/", record)
+			}
+		})
+		invoked = false
+		w := serve(s, tt.method, tt.path, nil, http.Header{"X-Abort": {tt.abort}})
+		if w.Code != tt.code || invoked != tt.expect {
+			t.Errorf("%d: want %d %t, got %d %t", i, tt.code, tt.expect, w.Code, invoked)
+		}
+	}
+}
+
+func TestTrailingSlashFilter(t *testing.T) {
+	auth := FilterFunc(func(req Request, resp Response, chain FilterChain) {
+		if req.GetHeader("Authorization") == "" {
+			resp.StatusCode(http.StatusUnauthorized)
+			return
+		}
+		chain(req, resp)
+	})
+	secret := func(req Request, resp Response) {
+		io.WriteString(resp, "secret")
+	}
+
+	for _, strict := range []bool{false, true} {
+		s := newTestServer(t, &ServerOption{StrictSlash: strict}, func(rt Router) {
+			rt.Filter("/admin/", auth)
+			rt.Handle("/admin", []string{METHOD_GET, METHOD_HEAD}, secret)
+			rt.Handle("/admin/users", []string{METHOD_GET, METHOD_HEAD}, secret)
+		})
+		for _, method := range []string{METHOD_GET, METHOD_HEAD} {
+			for _, path := range []string{"/admin", "/admin/", "/admin/users"} {
+				w := serve(s, method, path, nil, nil)
+				if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "secret") {
+					t.Errorf("strict %t, %s %s: filter bypassed, got %d %q", strict, method, path, w.Code, w.Body.String())
+				}
+			}
+		}
+	}
+}
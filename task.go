@@ -1,9 +1,40 @@
 package zerver
 
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cosiner/gohper/errors"
+)
+
+const (
+	ErrTaskTimeout  = errors.Err("task timeout")
+	ErrTaskPanic    = errors.Err("task panic")
+	ErrTaskCanceled = errors.Err("task canceled")
+
+	_TASK_VALUE_SUMMARY = 128 // max length of value summary in TaskInfo
+)
+
 type (
 	Task interface {
+		// ID is unique in server, see Server.InFlightTasks and CancelTask
+		ID() string
 		Pattern() string
 		Value() interface{}
+		// Context will be cancelled when task timeout or Server.CancelTask,
+		// long-running task should check it and return as soon as possible
+		Context() context.Context
+	}
+
+	// TaskInfo describe a task in flight
+	TaskInfo struct {
+		ID      string
+		Pattern string
+		Started time.Time
+		// Value formatted by "%v" when it's listed, it's truncated if too long
+		Value string
 	}
 
 	TaskHandlerFunc func(Task)
@@ -14,18 +45,38 @@ type (
 	}
 
 	task struct {
+		id      string
+		ctx     context.Context
 		pattern string
 		value   interface{}
 	}
+
+	// runningTask is an in-flight task tracked by server
+	runningTask struct {
+		info   TaskInfo
+		value  interface{}
+		cancel context.CancelFunc
+	}
+
+	timeoutTaskHandler struct {
+		TaskHandler
+		timeout time.Duration
+	}
 )
 
-func newTask(pattern string, value interface{}) Task {
+func newTask(ctx context.Context, id, pattern string, value interface{}) Task {
 	return task{
+		id:      id,
+		ctx:     ctx,
 		pattern: pattern,
 		value:   value,
 	}
 }
 
+func (t task) ID() string {
+	return t.id
+}
+
 func (t task) Pattern() string {
 	return t.pattern
 }
@@ -34,6 +85,32 @@ func (t task) Value() interface{} {
 	return t.value
 }
 
+func (t task) Context() context.Context {
+	return t.ctx
+}
+
+// valueSummary format v by "%v", it's truncated on rune boundary if too long
+func valueSummary(v interface{}) string {
+	s := fmt.Sprintf("%v", v)
+	if len(s) > _TASK_VALUE_SUMMARY {
+		n := _TASK_VALUE_SUMMARY
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n] + "..."
+	}
+	return s
+}
+
+// TimeoutTaskHandler wrap a task handler with it's own timeout, it will override
+// the ServerOption.TaskTimeout
+func TimeoutTaskHandler(th TaskHandler, timeout time.Duration) TaskHandler {
+	return timeoutTaskHandler{
+		TaskHandler: th,
+		timeout:     timeout,
+	}
+}
+
 func convertTaskHandler(i interface{}) TaskHandler {
 	switch t := i.(type) {
 	case func(Task):
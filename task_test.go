@@ -0,0 +1,82 @@
+package zerver
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestValueSummary(t *testing.T) {
+	long := strings.Repeat("a", _TASK_VALUE_SUMMARY-1) + "中文"
+	tests := []struct {
+		value  interface{}
+		expect string
+	}{
+		{nil, "<nil>"},
+		{1, "1"},
+		{"中文", "中文"},
+		{strings.Repeat("a", _TASK_VALUE_SUMMARY), strings.Repeat("a", _TASK_VALUE_SUMMARY)},
+		{strings.Repeat("a", _TASK_VALUE_SUMMARY+1), strings.Repeat("a", _TASK_VALUE_SUMMARY) + "..."},
+		{long, strings.Repeat("a", _TASK_VALUE_SUMMARY-1) + "..."},
+	}
+	for _, tt := range tests {
+		got := valueSummary(tt.value)
+		if got != tt.expect || !utf8.ValidString(got) {
+			t.Errorf("%v: want %q, got %q", tt.value, tt.expect, got)
+		}
+	}
+}
+
+func TestInFlightTasks(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := newTestServer(t, nil, func(rt Router) {
+		rt.TaskHandler("/task", TaskHandlerFunc(func(task Task) {
+			close(started)
+			<-release
+		}))
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StartTask("/task", []int{1, 2})
+	}()
+	<-started
+	tasks := s.InFlightTasks()
+	if len(tasks) != 1 || tasks[0].Pattern != "/task" || tasks[0].Value != "[1 2]" {
+		t.Fatalf("in-flight tasks: %+v", tasks)
+	}
+
+	if !s.CancelTask(tasks[0].ID) {
+		t.Fatal("task not found")
+	}
+	close(release)
+	select {
+	case err := <-done:
+		if err != ErrTaskCanceled {
+			t.Fatalf("want ErrTaskCanceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task not returned")
+	}
+	if tasks := s.InFlightTasks(); len(tasks) != 0 {
+		t.Fatalf("task not removed: %+v", tasks)
+	}
+}
+
+func TestTaskTimeoutAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := newTestServer(t, &ServerOption{TaskTimeout: 10 * time.Millisecond}, func(rt Router) {
+		rt.TaskHandler("/task", TaskHandlerFunc(func(task Task) {
+			<-release // ignore context
+		}))
+	})
+
+	if err := s.StartTask("/task", nil); err != ErrTaskTimeout {
+		t.Fatalf("want ErrTaskTimeout, got %v", err)
+	}
+	if tasks := s.InFlightTasks(); len(tasks) != 0 {
+		t.Fatalf("abandoned task is still tracked: %+v", tasks)
+	}
+}
@@ -17,6 +17,9 @@ const (
 	HEADER_AUTHRIZATION    = "Authorization"
 	HEADER_METHODOVERRIDE  = "X-HTTP-Method-Override"
 	HEADER_REALIP          = "X-Real-IP"
+	HEADER_EXPECT          = "Expect"
+	HEADER_ALLOW           = "Allow"
+	HEADER_RETRYAFTER      = "Retry-After"
 
 	// ContentEncoding
 	ENCODING_GZIP    = "gzip"
@@ -32,6 +35,12 @@ const (
 	METHOD_OPTIONS = "OPTIONS"
 )
 
+// routeMethods is the standard methods reported in "Allow" header
+var routeMethods = []string{
+	METHOD_GET, METHOD_HEAD, METHOD_POST, METHOD_PUT,
+	METHOD_PATCH, METHOD_DELETE, METHOD_OPTIONS,
+}
+
 func MethodName(s string) string {
 	if s == "" {
 		return METHOD_GET
@@ -1,8 +1,10 @@
 package monitor
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
+	"reflect"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
@@ -116,6 +118,11 @@ func initRoutes() bool {
 			req.Server().PrintRouteTree(resp)
 		})
 
+	Handle("/config", "Get server options, secrets are redacted",
+		func(req zerver.Request, resp zerver.Response) {
+			resp.Send(optionValues(req.Server().Options()))
+		})
+
 	Handle("/options", "Get all pprof options",
 		func(req zerver.Request, resp zerver.Response) {
 			if from := req.Vars().QueryVar("from"); from != "" {
@@ -128,3 +135,29 @@ func initRoutes() bool {
 
 	return inited
 }
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// optionValues convert options to an encodable map, durations are formatted,
+// functions and objects are replaced by their type name
+func optionValues(o zerver.ServerOption) map[string]interface{} {
+	v := reflect.ValueOf(o)
+	t := v.Type()
+	values := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Type() == durationType:
+			values[t.Field(i).Name] = f.Interface().(time.Duration).String()
+		case f.Kind() == reflect.Func || f.Kind() == reflect.Interface || f.Kind() == reflect.Ptr:
+			if f.IsNil() {
+				values[t.Field(i).Name] = nil
+			} else {
+				values[t.Field(i).Name] = fmt.Sprintf("%T", f.Interface())
+			}
+		default:
+			values[t.Field(i).Name] = f.Interface()
+		}
+	}
+	return values
+}
@@ -0,0 +1,233 @@
+// Package openapi generate a basic OpenAPI 3.0 document from registered routes,
+// paths, methods and path parameters are collected from route table, other
+// informations can be supplied by handlers implement Describer.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cosiner/zerver"
+)
+
+const VERSION = "3.0.3"
+
+type (
+	// Describer supply operation metadata of a handler, nil means there is
+	// nothing provided for the method
+	Describer interface {
+		Describe(method string) *Operation
+	}
+
+	Document struct {
+		OpenAPI string               `json:"openapi"`
+		Info    Info                 `json:"info"`
+		Paths   map[string]*PathItem `json:"paths"`
+	}
+
+	Info struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Version     string `json:"version"`
+	}
+
+	// PathItem is map of lower case method to operation
+	PathItem map[string]*Operation
+
+	Operation struct {
+		Summary     string               `json:"summary,omitempty"`
+		Description string               `json:"description,omitempty"`
+		OperationID string               `json:"operationId,omitempty"`
+		Tags        []string             `json:"tags,omitempty"`
+		Parameters  []Parameter          `json:"parameters,omitempty"`
+		RequestBody *RequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*Response `json:"responses"`
+	}
+
+	Parameter struct {
+		Name        string `json:"name"`
+		In          string `json:"in"` // "path", "query", "header" or "cookie"
+		Description string `json:"description,omitempty"`
+		Required    bool   `json:"required,omitempty"`
+		Schema      Schema `json:"schema,omitempty"`
+	}
+
+	RequestBody struct {
+		Description string               `json:"description,omitempty"`
+		Required    bool                 `json:"required,omitempty"`
+		Content     map[string]MediaType `json:"content"`
+	}
+
+	Response struct {
+		Description string               `json:"description"`
+		Content     map[string]MediaType `json:"content,omitempty"`
+	}
+
+	MediaType struct {
+		Schema Schema `json:"schema,omitempty"`
+	}
+
+	// Schema is a json schema object such as {"type": "string"}
+	Schema map[string]interface{}
+
+	described struct {
+		handler zerver.Handler
+		ops     map[string]*Operation
+	}
+)
+
+// Describe wrap a handler with operations of methods, it can be registered
+// directly
+func Describe(h zerver.Handler, ops map[string]*Operation) zerver.Handler {
+	described := &described{
+		handler: h,
+		ops:     make(map[string]*Operation, len(ops)),
+	}
+	for m, op := range ops {
+		described.ops[zerver.MethodName(m)] = op
+	}
+	return described
+}
+
+func (d *described) Init(env zerver.Env) error {
+	return d.handler.Init(env)
+}
+
+func (d *described) Destroy() {
+	d.handler.Destroy()
+}
+
+func (d *described) Handler(method string) zerver.HandleFunc {
+	return d.handler.Handler(method)
+}
+
+func (d *described) Describe(method string) *Operation {
+	if op := d.ops[method]; op != nil {
+		return op
+	}
+	if desc, is := d.handler.(Describer); is {
+		return desc.Describe(method)
+	}
+	return nil
+}
+
+// Generate create document from handler routes of router, websocket, task and
+// fallback routes are ignored, so is HEAD and OPTIONS methods unless they are
+// described by handler
+func Generate(rt zerver.Router, info Info) *Document {
+	doc := &Document{
+		OpenAPI: VERSION,
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+	}
+
+	for _, route := range rt.Routes() {
+		if route.Kind != "handler" {
+			continue
+		}
+
+		path, params := convertPattern(route.Pattern)
+		item := doc.Paths[path]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+
+		desc, _ := route.Handler.(Describer)
+		for _, method := range route.Methods {
+			var op *Operation
+			if desc != nil {
+				op = desc.Describe(method)
+			}
+			if op == nil {
+				if method == zerver.METHOD_HEAD || method == zerver.METHOD_OPTIONS {
+					continue
+				}
+				op = &Operation{}
+			}
+			(*item)[strings.ToLower(method)] = completeOperation(op, params)
+		}
+	}
+	return doc
+}
+
+// completeOperation return a copy of operation with missing path parameters
+// and default response added
+func completeOperation(op *Operation, params []string) *Operation {
+	o := *op
+	o.Parameters = append([]Parameter(nil), op.Parameters...)
+	for _, name := range params {
+		if !hasParameter(o.Parameters, name) {
+			o.Parameters = append(o.Parameters, Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   Schema{"type": "string"},
+			})
+		}
+	}
+	if len(o.Responses) == 0 {
+		o.Responses = map[string]*Response{
+			"default": {Description: "default response"},
+		}
+	}
+	return &o
+}
+
+func hasParameter(params []Parameter, name string) bool {
+	for _, p := range params {
+		if p.In == "path" && p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// convertPattern convert route pattern to openapi path, ":name" and "*name" is
+// replaced with "{name}", unnamed variables is named by their index
+func convertPattern(pattern string) (string, []string) {
+	if i := strings.IndexByte(pattern, '?'); i >= 0 {
+		pattern = pattern[:i]
+	}
+
+	var params []string
+	sections := strings.Split(pattern, "/")
+	for i, s := range sections {
+		at := strings.LastIndexAny(s, ":*")
+		if at < 0 {
+			continue
+		}
+
+		name := s[at+1:]
+		if name == "" {
+			name = "param" + strconv.Itoa(len(params))
+		}
+		params = append(params, name)
+		sections[i] = s[:at] + "{" + name + "}"
+	}
+	return strings.Join(sections, "/"), params
+}
+
+// Handler serve document as json, it's generated once on first request, after
+// all routes registered
+func Handler(rt zerver.Router, info Info) zerver.HandleFunc {
+	var (
+		once sync.Once
+		data []byte
+		err  error
+	)
+	return func(req zerver.Request, resp zerver.Response) {
+		once.Do(func() {
+			data, err = json.Marshal(Generate(rt, info))
+		})
+		if err != nil {
+			resp.StatusCode(http.StatusInternalServerError)
+			return
+		}
+		resp.Headers().Set(zerver.HEADER_CONTENTTYPE, "application/json; charset=utf-8")
+		resp.Write(data)
+	}
+}
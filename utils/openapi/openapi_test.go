@@ -0,0 +1,68 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cosiner/zerver"
+)
+
+func TestConvertPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		params  []string
+	}{
+		{"/", "/", nil},
+		{"/users", "/users", nil},
+		{"/users/:id", "/users/{id}", []string{"id"}},
+		{"/users/:id/posts/:post", "/users/{id}/posts/{post}", []string{"id", "post"}},
+		{"/files/*path", "/files/{path}", []string{"path"}},
+		{"/img/:", "/img/{param0}", []string{"param0"}},
+		{"/v/:a/:", "/v/{a}/{param1}", []string{"a", "param1"}},
+		{"/file.:ext", "/file.{ext}", []string{"ext"}},
+		{"/search?q", "/search", nil},
+	}
+	for _, tt := range tests {
+		path, params := convertPattern(tt.pattern)
+		if path != tt.path || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("%q: got %q %v, expect %q %v", tt.pattern, path, params, tt.path, tt.params)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	rt := zerver.NewRouter()
+	rt.Handle("/users/:id", []string{"GET", "DELETE"}, zerver.NopHandleFunc)
+	rt.Handler("/users", Describe(
+		zerver.HandlerFunc(func(method string) zerver.HandleFunc {
+			if method == "POST" {
+				return zerver.NopHandleFunc
+			}
+			return nil
+		}),
+		map[string]*Operation{"post": {Summary: "create user"}},
+	))
+
+	doc := Generate(rt, Info{Title: "test", Version: "1"})
+	if doc.OpenAPI != VERSION || len(doc.Paths) != 2 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+
+	item := *doc.Paths["/users/{id}"]
+	if len(item) != 2 || item["get"] == nil || item["delete"] == nil {
+		t.Fatalf("unexpected operations %v", item)
+	}
+	get := item["get"]
+	if len(get.Parameters) != 1 || get.Parameters[0].Name != "id" || get.Parameters[0].In != "path" {
+		t.Errorf("unexpected parameters %v", get.Parameters)
+	}
+	if get.Responses["default"] == nil {
+		t.Error("default response is not added")
+	}
+
+	post := (*doc.Paths["/users"])["post"]
+	if post == nil || post.Summary != "create user" {
+		t.Errorf("described operation is lost: %+v", post)
+	}
+}
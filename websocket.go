@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cosiner/gohper/unsafe2"
+	"github.com/cosiner/gohper/utils/attrs"
 	websocket "github.com/cosiner/zerver_websocket"
 )
 
@@ -16,6 +17,9 @@ type (
 		Env
 
 		Vars() *ReqVars
+		// Attr return attribute setted by filters before upgrade, it's only
+		// available before handler return
+		Attr(name string) interface{}
 		WriteString(string) (int, error)
 		SetDeadline(t time.Time) error
 		SetReadDeadline(t time.Time) error
@@ -26,7 +30,8 @@ type (
 
 	wsConn struct {
 		Env
-		vars *ReqVars
+		vars  *ReqVars
+		attrs attrs.Attrs
 		*websocket.Conn
 		request *http.Request
 	}
@@ -39,11 +44,12 @@ type (
 	}
 )
 
-func newWsConn(e Env, conn *websocket.Conn, vars *ReqVars) *wsConn {
+func newWsConn(e Env, conn *websocket.Conn, vars *ReqVars, attrs attrs.Attrs) *wsConn {
 	return &wsConn{
 		Env:     e,
 		Conn:    conn,
 		vars:    vars,
+		attrs:   attrs,
 		request: conn.Request(),
 	}
 }
@@ -52,6 +58,10 @@ func (c *wsConn) Vars() *ReqVars {
 	return c.vars
 }
 
+func (c *wsConn) Attr(name string) interface{} {
+	return c.attrs.Attr(name)
+}
+
 func (c *wsConn) WriteString(s string) (int, error) {
 	return c.Write(unsafe2.Bytes(s))
 }